@@ -0,0 +1,712 @@
+package gogitstatus
+
+import (
+	"errors"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// The values of one or more Git config files, later values overriding earlier ones.
+// See: https://git-scm.com/docs/git-config
+type Config struct {
+	entries []configEntry
+}
+
+type configEntry struct {
+	key      string // "section.name" or "section.subsection.name", the section and name are lowercase
+	value    string
+	hasValue bool // A key without "=" has no value, which means true for a boolean
+}
+
+// Git stops following includes after this many levels
+const maxConfigIncludeDepth = 10
+
+// Returns the lowercase section and name of key, keeping the case of the subsection
+func normalizeConfigKey(key string) string {
+	first := strings.IndexByte(key, '.')
+	last := strings.LastIndexByte(key, '.')
+	if first == -1 {
+		return strings.ToLower(key)
+	}
+	return strings.ToLower(key[:first]) + key[first:last] + strings.ToLower(key[last:])
+}
+
+// Returns the last value of key, like `git config --get`.
+// key is "section.name" or "section.subsection.name", the section and name are case-insensitive, the subsection is not.
+// A key without a value (no "=") returns an empty string.
+func (c *Config) Get(key string) (string, bool) {
+	key = normalizeConfigKey(key)
+	for i := len(c.entries) - 1; i >= 0; i-- {
+		if c.entries[i].key == key {
+			return c.entries[i].value, true
+		}
+	}
+	return "", false
+}
+
+// Returns all the values of key in order, like `git config --get-all`
+func (c *Config) GetAll(key string) []string {
+	key = normalizeConfigKey(key)
+	var ret []string
+	for _, e := range c.entries {
+		if e.key == key {
+			ret = append(ret, e.value)
+		}
+	}
+	return ret
+}
+
+// Returns the last value of key as a boolean, like `git config --type=bool --get`.
+// found is false if the key is missing.
+func (c *Config) GetBool(key string) (value bool, found bool, err error) {
+	key = normalizeConfigKey(key)
+	for i := len(c.entries) - 1; i >= 0; i-- {
+		e := c.entries[i]
+		if e.key != key {
+			continue
+		}
+
+		if !e.hasValue {
+			return true, true, nil
+		}
+
+		value, err = parseGitConfigBool(e.value)
+		if err != nil {
+			return false, true, errors.New("bad boolean config value '" + e.value + "' for '" + key + "'")
+		}
+		return value, true, nil
+	}
+	return false, false, nil
+}
+
+// The config values that change how Status() compares tracked files, see readStatusSettings()
+type statusSettings struct {
+	fileMode bool // core.fileMode, whether the executable bit is compared
+	symlinks bool // core.symlinks, whether symbolic links are checked out as symbolic links instead of regular files containing the target path
+
+	// core.ignoreCase, whether paths on disk are matched against the index and .gitignore patterns case-insensitively
+	ignoreCase bool
+
+	// core.precomposeUnicode, whether paths on disk are matched against the index and .gitignore patterns
+	// regardless of their Unicode normalization form (NFC or NFD, macOS uses NFD)
+	precomposeUnicode bool
+}
+
+func readStatusSettings(config *Config) (*statusSettings, error) {
+	fileMode, found, err := config.GetBool("core.filemode")
+	if err != nil {
+		return nil, err
+	}
+
+	symlinks, symlinksFound, err := config.GetBool("core.symlinks")
+	if err != nil {
+		return nil, err
+	}
+
+	// Git for Windows defaults to false, since creating symbolic links needs special permissions there
+	if !symlinksFound {
+		symlinks = runtime.GOOS != "windows"
+	}
+
+	ignoreCase, _, err := config.GetBool("core.ignorecase")
+	if err != nil {
+		return nil, err
+	}
+
+	precomposeUnicode, _, err := config.GetBool("core.precomposeunicode")
+	if err != nil {
+		return nil, err
+	}
+
+	return &statusSettings{
+		// Windows doesn't have an executable bit on disk, it is only stored in the index
+		fileMode:   (fileMode || !found) && runtime.GOOS != "windows",
+		symlinks:   symlinks,
+		ignoreCase: ignoreCase,
+
+		precomposeUnicode: precomposeUnicode,
+	}, nil
+}
+
+// Returns path in the form used to look it up in the index,
+// precomposed (NFC) with precomposeUnicode and case-folded with ignoreCase
+func (settings *statusSettings) normalizePath(path string) string {
+	path = settings.precomposePath(path)
+	if settings.ignoreCase {
+		path = foldCase(path)
+	}
+	return path
+}
+
+// Returns path precomposed (NFC) with precomposeUnicode, the form used to match it against ignore patterns
+func (settings *statusSettings) precomposePath(path string) string {
+	if settings.precomposeUnicode {
+		return norm.NFC.String(path)
+	}
+	return path
+}
+
+// Reads the config files of a repository in the same order as Git, later files overriding earlier ones:
+//  1. The system config, /etc/gitconfig or $GIT_CONFIG_SYSTEM, skipped if $GIT_CONFIG_NOSYSTEM is true
+//  2. The global config, $XDG_CONFIG_HOME/git/config (or ~/.config/git/config) and ~/.gitconfig, or only $GIT_CONFIG_GLOBAL
+//  3. The repository config, "config" in repo.CommonDir
+//  4. The worktree config, "config.worktree" in repo.GitDir, if extensions.worktreeConfig is true
+//
+// Missing files are skipped. include.path and includeIf.<condition>.path are followed,
+// the supported conditions are "gitdir:", "gitdir/i:" and "onbranch:".
+func ReadConfig(repo *Repository) (*Config, error) {
+	config := &Config{}
+
+	var paths []string
+	if noSystem, _ := parseGitConfigBool(os.Getenv("GIT_CONFIG_NOSYSTEM")); !noSystem {
+		paths = append(paths, systemConfigPath())
+	}
+	paths = append(paths, globalConfigPaths()...)
+	paths = append(paths, filepath.Join(repo.CommonDir, "config"))
+
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		err := config.readFile(path, repo.GitDir, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	worktreeConfig, _, err := config.GetBool("extensions.worktreeconfig")
+	if err != nil {
+		return nil, err
+	}
+
+	if worktreeConfig {
+		err = config.readFile(filepath.Join(repo.GitDir, "config.worktree"), repo.GitDir, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return config, nil
+}
+
+// Reads a single config file, following include.path but not includeIf since there is no repository to check the conditions against.
+// A missing file gives an empty Config.
+func ReadConfigFile(path string) (*Config, error) {
+	config := &Config{}
+	err := config.readFile(path, "", 0)
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Reads the config file of a repository without following includes, like Git does when checking the repository format.
+func readRepositoryConfig(commonDirPath string) (*Config, error) {
+	config := &Config{}
+	err := config.readFile(filepath.Join(commonDirPath, "config"), "", -1)
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func systemConfigPath() string {
+	if path := os.Getenv("GIT_CONFIG_SYSTEM"); path != "" {
+		return path
+	}
+
+	if runtime.GOOS == "windows" {
+		programFiles := os.Getenv("PROGRAMFILES")
+		if programFiles == "" {
+			return ""
+		}
+		return filepath.Join(programFiles, "Git", "etc", "gitconfig")
+	}
+
+	return "/etc/gitconfig"
+}
+
+func globalConfigPaths() []string {
+	if path := os.Getenv("GIT_CONFIG_GLOBAL"); path != "" {
+		return []string{path}
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = ""
+	}
+
+	var paths []string
+	if xdgConfigHome := os.Getenv("XDG_CONFIG_HOME"); xdgConfigHome != "" {
+		paths = append(paths, filepath.Join(xdgConfigHome, "git", "config"))
+	} else if home != "" {
+		paths = append(paths, filepath.Join(home, ".config", "git", "config"))
+	}
+
+	if home != "" {
+		paths = append(paths, filepath.Join(home, ".gitconfig"))
+	}
+
+	return paths
+}
+
+// Returns the path of the global excludes file, which is core.excludesFile or $XDG_CONFIG_HOME/git/ignore by default.
+// Returns an empty string if there is none.
+func globalExcludesFilePath(config *Config) (string, error) {
+	if path, found := config.Get("core.excludesfile"); found {
+		if path == "" {
+			return "", nil
+		}
+
+		expanded, err := expandTildePath(path)
+		if err != nil {
+			return "", errors.New("failed to expand user dir in core.excludesFile: " + path)
+		}
+		return expanded, nil
+	}
+
+	if xdgConfigHome := os.Getenv("XDG_CONFIG_HOME"); xdgConfigHome != "" {
+		return filepath.Join(xdgConfigHome, "git", "ignore"), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", nil
+	}
+	return filepath.Join(home, ".config", "git", "ignore"), nil
+}
+
+// Expands a leading "~/" to the home folder and "~user/" to the home folder of user, like Git does for paths in config values
+func expandTildePath(path string) (string, error) {
+	if !strings.HasPrefix(path, "~") {
+		return path, nil
+	}
+
+	name, rest, _ := strings.Cut(path[1:], "/")
+	var home string
+	if name == "" {
+		var err error
+		home, err = os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+	} else {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", err
+		}
+		home = u.HomeDir
+	}
+
+	return filepath.Join(home, filepath.FromSlash(rest)), nil
+}
+
+// Appends the values of the config file at path, a negative depth means includes are not followed.
+// Missing files are skipped, like Git does for includes.
+// gitDirPath is used for the includeIf conditions, which are never true if it is empty.
+func (c *Config) readFile(path, gitDirPath string, depth int) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	return c.parse(data, path, gitDirPath, depth)
+}
+
+func isConfigKeyChar(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '-'
+}
+
+func isConfigSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+// Reads the bytes of a config file like get_next_char() in Git, turning "\r\n" into "\n" and returning "\n" at the end
+type configReader struct {
+	data   []byte
+	offset int
+	line   int
+	eof    bool
+}
+
+func (r *configReader) next() byte {
+	if r.offset >= len(r.data) {
+		r.eof = true
+		return '\n'
+	}
+
+	c := r.data[r.offset]
+	r.offset++
+	if c == '\r' && r.offset < len(r.data) && r.data[r.offset] == '\n' {
+		c = '\n'
+		r.offset++
+	}
+	if c == '\n' {
+		r.line++
+	}
+	return c
+}
+
+// https://github.com/git/git/blob/ef8ce8f3d4344fd3af049c17eeba5cd20d98b69f/config.c
+func (c *Config) parse(data []byte, path, gitDirPath string, depth int) error {
+	r := &configReader{data: data, line: 1}
+
+	// Skip the UTF-8 byte order mark
+	if len(data) >= 3 && data[0] == 0xef && data[1] == 0xbb && data[2] == 0xbf {
+		r.offset = 3
+	}
+
+	badLine := func() error {
+		return errors.New("bad config line " + strconv.Itoa(r.line) + " in file " + path)
+	}
+
+	section := ""
+	comment := false
+	for {
+		ch := r.next()
+		if ch == '\n' {
+			if r.eof {
+				return nil
+			}
+			comment = false
+			continue
+		}
+
+		if comment || isConfigSpace(ch) {
+			continue
+		}
+
+		if ch == '#' || ch == ';' {
+			comment = true
+			continue
+		}
+
+		if ch == '[' {
+			var ok bool
+			section, ok = parseConfigSectionHeader(r)
+			if !ok || section == "" {
+				return badLine()
+			}
+			continue
+		}
+
+		if !((ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')) || section == "" {
+			return badLine()
+		}
+
+		var name strings.Builder
+		name.WriteByte(asciiToLower(ch))
+		for {
+			ch = r.next()
+			if r.eof || !isConfigKeyChar(ch) {
+				break
+			}
+			name.WriteByte(asciiToLower(ch))
+		}
+
+		for ch == ' ' || ch == '\t' {
+			ch = r.next()
+		}
+
+		entry := configEntry{key: section + "." + name.String()}
+		if ch != '\n' {
+			if ch != '=' {
+				return badLine()
+			}
+
+			value, ok := parseConfigValue(r)
+			if !ok {
+				return badLine()
+			}
+			entry.value = value
+			entry.hasValue = true
+		}
+
+		c.entries = append(c.entries, entry)
+
+		if depth >= 0 {
+			err := c.followInclude(entry, path, gitDirPath, depth)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Parses the rest of a "[section]" or "[section "subsection"]" header after the "[".
+// Returns the section in lowercase followed by the subsection, like "remote.origin".
+func parseConfigSectionHeader(r *configReader) (string, bool) {
+	var name strings.Builder
+	var ch byte
+	for {
+		ch = r.next()
+		if r.eof {
+			return "", false
+		}
+
+		if ch == ']' {
+			// The deprecated [section.subsection] syntax is case-insensitive, so it is lowercased too
+			return name.String(), true
+		}
+
+		if isConfigSpace(ch) {
+			break
+		}
+
+		if !isConfigKeyChar(ch) && ch != '.' {
+			return "", false
+		}
+		name.WriteByte(asciiToLower(ch))
+	}
+
+	// [section "subsection"]
+	for isConfigSpace(ch) {
+		if ch == '\n' {
+			return "", false
+		}
+		ch = r.next()
+	}
+
+	if ch != '"' {
+		return "", false
+	}
+
+	name.WriteByte('.')
+	for {
+		ch = r.next()
+		if ch == '\n' {
+			return "", false
+		}
+		if ch == '"' {
+			break
+		}
+		if ch == '\\' {
+			ch = r.next()
+			if ch == '\n' {
+				return "", false
+			}
+		}
+		name.WriteByte(ch)
+	}
+
+	if r.next() != ']' {
+		return "", false
+	}
+
+	return name.String(), true
+}
+
+// Parses a value after the "=", up to the end of the line.
+// Whitespace around the value is removed unless quoted, and comments are skipped.
+func parseConfigValue(r *configReader) (string, bool) {
+	var value strings.Builder
+	quote := false
+	comment := false
+	trimLength := -1
+	for {
+		ch := r.next()
+		if ch == '\n' {
+			if quote {
+				return "", false
+			}
+			ret := value.String()
+			if trimLength >= 0 {
+				ret = ret[:trimLength]
+			}
+			return ret, true
+		}
+
+		if comment {
+			continue
+		}
+
+		if isConfigSpace(ch) && !quote {
+			if trimLength < 0 {
+				trimLength = value.Len()
+			}
+			if value.Len() > 0 {
+				value.WriteByte(ch)
+			}
+			continue
+		}
+
+		if !quote && (ch == ';' || ch == '#') {
+			comment = true
+			continue
+		}
+
+		trimLength = -1
+
+		if ch == '\\' {
+			ch = r.next()
+			switch ch {
+			case '\n':
+				// A backslash at the end of the line continues the value on the next line
+				if r.eof {
+					return "", false
+				}
+				continue
+			case 't':
+				ch = '\t'
+			case 'b':
+				ch = '\b'
+			case 'n':
+				ch = '\n'
+			case '\\', '"':
+			default:
+				return "", false
+			}
+			value.WriteByte(ch)
+			continue
+		}
+
+		if ch == '"' {
+			quote = !quote
+			continue
+		}
+
+		value.WriteByte(ch)
+	}
+}
+
+// Reads the file of an include.path or a matching includeIf.<condition>.path entry
+func (c *Config) followInclude(entry configEntry, path, gitDirPath string, depth int) error {
+	if entry.key != "include.path" {
+		condition, found := strings.CutPrefix(entry.key, "includeif.")
+		if !found {
+			return nil
+		}
+
+		condition, found = strings.CutSuffix(condition, ".path")
+		if !found {
+			return nil
+		}
+
+		matched, err := includeConditionMatches(condition, path, gitDirPath)
+		if err != nil || !matched {
+			return err
+		}
+	}
+
+	if !entry.hasValue {
+		return errors.New("missing value for '" + entry.key + "' in file " + path)
+	}
+
+	includePath, err := expandTildePath(entry.value)
+	if err != nil {
+		return err
+	}
+
+	// Relative to the folder of the including file
+	if !filepath.IsAbs(includePath) {
+		includePath = filepath.Join(filepath.Dir(path), includePath)
+	}
+
+	if depth+1 > maxConfigIncludeDepth {
+		return errors.New("exceeded maximum include depth (" + strconv.Itoa(maxConfigIncludeDepth) + ") while including " + includePath + " from " + path)
+	}
+
+	return c.readFile(includePath, gitDirPath, depth+1)
+}
+
+// https://git-scm.com/docs/git-config#_conditional_includes
+func includeConditionMatches(condition, configPath, gitDirPath string) (bool, error) {
+	if gitDirPath == "" {
+		return false, nil
+	}
+
+	if pattern, found := strings.CutPrefix(condition, "gitdir:"); found {
+		return includeGitDirMatches(pattern, configPath, gitDirPath, 0)
+	}
+
+	if pattern, found := strings.CutPrefix(condition, "gitdir/i:"); found {
+		return includeGitDirMatches(pattern, configPath, gitDirPath, wildmatchCaseFold)
+	}
+
+	if pattern, found := strings.CutPrefix(condition, "onbranch:"); found {
+		head, err := os.ReadFile(filepath.Join(gitDirPath, "HEAD"))
+		if err != nil {
+			return false, nil
+		}
+
+		branch, found := strings.CutPrefix(strings.TrimSpace(string(head)), "ref: refs/heads/")
+		if !found {
+			return false, nil
+		}
+
+		if strings.HasSuffix(pattern, "/") {
+			pattern += "**"
+		}
+		return wildmatch(pattern, branch, wildmatchPathname), nil
+	}
+
+	// Unknown conditions, like "hasconfig:", are never true
+	return false, nil
+}
+
+func includeGitDirMatches(pattern, configPath, gitDirPath string, flags int) (bool, error) {
+	pattern, err := expandTildePath(pattern)
+	if err != nil {
+		return false, err
+	}
+
+	// Relative to the folder of the including file
+	if rest, found := strings.CutPrefix(pattern, "./"); found {
+		trailingSlash := strings.HasSuffix(rest, "/")
+		pattern = filepath.Join(filepath.Dir(configPath), rest)
+		if trailingSlash {
+			pattern += "/"
+		}
+	}
+
+	pattern = filepath.ToSlash(pattern)
+	if !filepath.IsAbs(filepath.FromSlash(pattern)) && !strings.HasPrefix(pattern, "/") {
+		pattern = "**/" + pattern
+	}
+
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+
+	gitDir, err := filepath.Abs(gitDirPath)
+	if err != nil {
+		return false, err
+	}
+
+	if wildmatch(pattern, filepath.ToSlash(gitDir), flags|wildmatchPathname) {
+		return true, nil
+	}
+
+	// Git also tries the path with symbolic links resolved
+	realGitDir, err := filepath.EvalSymlinks(gitDir)
+	if err != nil {
+		return false, nil
+	}
+	return wildmatch(pattern, filepath.ToSlash(realGitDir), flags|wildmatchPathname), nil
+}
+
+// https://git-scm.com/docs/git-config#Documentation/git-config.txt-boolean
+func parseGitConfigBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "true", "yes", "on":
+		return true, nil
+	case "false", "no", "off", "":
+		return false, nil
+	}
+
+	// Any integer, where 0 is false
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return false, errors.New("invalid boolean config value: " + value)
+	}
+	return number != 0, nil
+}
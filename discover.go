@@ -0,0 +1,224 @@
+package gogitstatus
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Options for DiscoverRepository()
+type DiscoverOptions struct {
+	// The search does not go up into these folders, in addition to the ones in the GIT_CEILING_DIRECTORIES environment variable.
+	// The folder the search starts in is always checked.
+	CeilingDirectories []string
+
+	// Keep searching in parent folders on other filesystems, like GIT_DISCOVERY_ACROSS_FILESYSTEM=true
+	AcrossFilesystems bool
+
+	// Use the GIT_DIR, GIT_WORK_TREE and GIT_INDEX_FILE environment variables, and the core.worktree and core.bare config values, like Git does.
+	// Like in Git, relative paths in GIT_DIR and GIT_WORK_TREE are relative to the current folder of the process, not to startPath.
+	// startPath is still the work tree when GIT_DIR is set without a work tree, like the current folder is for Git.
+	UseEnvironment bool
+}
+
+// Finds the repository containing startPath by searching upward through its parent folders, like `git rev-parse --show-toplevel --git-dir`.
+// The paths of the returned Repository are absolute. Symbolic links are not resolved, so WorkTree is a parent folder of startPath.
+// A bare repository has an empty WorkTree.
+// https://github.com/git/git/blob/ef8ce8f3d4344fd3af049c17eeba5cd20d98b69f/setup.c
+func DiscoverRepository(startPath string, options DiscoverOptions) (*Repository, error) {
+	dir, err := filepath.Abs(startPath)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := os.Stat(dir)
+	if err != nil || !stat.IsDir() {
+		return nil, errors.New("path does not exist: " + startPath)
+	}
+
+	if options.UseEnvironment {
+		return discoverRepositoryFromEnvironment(dir, stat, options)
+	}
+
+	return discoverRepository(dir, stat, options)
+}
+
+func discoverRepository(dir string, stat os.FileInfo, options DiscoverOptions) (*Repository, error) {
+	startDir := dir
+
+	startDevice, knownDevice := fileDevice(stat)
+
+	ceilingDirectories := append(filepath.SplitList(os.Getenv("GIT_CEILING_DIRECTORIES")), options.CeilingDirectories...)
+	ceilingLength := longestCeilingDirectoryLength(dir, ceilingDirectories)
+
+	for {
+		// A ".git" folder or file
+		dotGitPath := filepath.Join(dir, ".git")
+		if stat, err := os.Stat(dotGitPath); err == nil {
+			if !stat.IsDir() {
+				gitDir, err := resolveDotGit(dotGitPath)
+				if err != nil {
+					return nil, err
+				}
+				return openRepositoryWithGitDir(dir, gitDir)
+			}
+
+			// Like Git, we keep searching upward past a ".git" folder that is not a Git directory
+			if isGitDirectory(dotGitPath) {
+				return openRepositoryWithGitDir(dir, dotGitPath)
+			}
+		}
+
+		// A bare repository
+		if isGitDirectory(dir) {
+			repo, err := openRepositoryWithGitDir(dir, dir)
+			if err != nil {
+				return nil, err
+			}
+			repo.WorkTree = ""
+			return repo, nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir || len(parent) <= ceilingLength {
+			return nil, errors.New("not a Git repository (or any of the parent directories): " + startDir)
+		}
+
+		if !options.AcrossFilesystems && knownDevice {
+			parentStat, err := os.Stat(parent)
+			if err != nil {
+				return nil, err
+			}
+
+			if parentDevice, ok := fileDevice(parentStat); ok && parentDevice != startDevice {
+				return nil, errors.New("not a Git repository (or any parent up to mount point " + dir + "), stopping at filesystem boundary")
+			}
+		}
+
+		dir = parent
+	}
+}
+
+// Git checks these in setup_explicit_git_dir() and setup_discovered_git_dir()
+func discoverRepositoryFromEnvironment(dir string, stat os.FileInfo, options DiscoverOptions) (*Repository, error) {
+	var repo *Repository
+	var err error
+
+	gitDirEnv := os.Getenv("GIT_DIR")
+	if gitDirEnv != "" {
+		// GIT_DIR may also be a ".git" file pointing to the Git directory
+		gitDirPath, err := filepath.Abs(gitDirEnv)
+		if err != nil {
+			return nil, err
+		}
+
+		gitDir, err := resolveDotGit(gitDirPath)
+		if err != nil || !isGitDirectory(gitDir) {
+			return nil, errors.New("not a Git repository: " + gitDirEnv)
+		}
+
+		// Without GIT_WORK_TREE or core.worktree, the current folder is the work tree
+		repo, err = openRepositoryWithGitDir(dir, gitDir)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		repo, err = discoverRepository(dir, stat, options)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Like Git, these are only read from the repository config
+	config, err := readRepositoryConfig(repo.CommonDir)
+	if err != nil {
+		return nil, err
+	}
+
+	coreWorkTree, hasCoreWorkTree := config.Get("core.worktree")
+	bare, _, err := config.GetBool("core.bare")
+	if err != nil {
+		return nil, err
+	}
+
+	if workTreeEnv := os.Getenv("GIT_WORK_TREE"); workTreeEnv != "" {
+		repo.WorkTree, err = filepath.Abs(workTreeEnv)
+		if err != nil {
+			return nil, err
+		}
+	} else if bare {
+		// Git ignores core.worktree with a warning when core.bare is also set
+		repo.WorkTree = ""
+	} else if hasCoreWorkTree {
+		// Relative to the Git directory
+		repo.WorkTree = absolutePathFrom(repo.GitDir, coreWorkTree)
+	}
+
+	if indexFileEnv := os.Getenv("GIT_INDEX_FILE"); indexFileEnv != "" {
+		// Git changes into the work tree before reading the index, so a relative path is relative to it
+		base := repo.WorkTree
+		if base == "" {
+			base = dir
+		}
+		repo.IndexFile = absolutePathFrom(base, indexFileEnv)
+	}
+
+	return repo, nil
+}
+
+// Returns path if it is absolute, otherwise path joined onto base
+func absolutePathFrom(base, path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(base, path)
+}
+
+// Returns the length of the longest ceiling directory that is a parent folder of dir, or -1 if there is none.
+// Relative ceiling directories are ignored, like in Git.
+func longestCeilingDirectoryLength(dir string, ceilingDirectories []string) int {
+	longest := -1
+	for _, ceiling := range ceilingDirectories {
+		if !filepath.IsAbs(ceiling) {
+			continue
+		}
+
+		ceiling = filepath.Clean(ceiling)
+		prefix := ceiling
+		if !strings.HasSuffix(prefix, string(os.PathSeparator)) {
+			prefix += string(os.PathSeparator)
+		}
+
+		// The folder itself does not count
+		if strings.HasPrefix(dir, prefix) && len(ceiling) > longest {
+			longest = len(ceiling)
+		}
+	}
+
+	return longest
+}
+
+// Returns true if path looks like a Git directory, with a valid HEAD file and the "objects" and "refs" folders.
+// The repository format version is not checked.
+func isGitDirectory(path string) bool {
+	commonDir, err := readCommonDir(path)
+	return err == nil && checkGitDirectoryLayout(path, commonDir) == nil
+}
+
+// Use this function to make the paths of changedFiles relative to directory instead of the root folder of the repository,
+// like `git status` does when run in a subfolder. Paths outside of directory start with "..".
+// workTree and directory should both be absolute, or both be relative to the same folder.
+// Does not modify the changedFiles input argument.
+func RelativeToDirectory(changedFiles map[string]ChangedFile, workTree, directory string) (map[string]ChangedFile, error) {
+	ret := make(map[string]ChangedFile, len(changedFiles))
+	for path, e := range changedFiles {
+		rel, err := filepath.Rel(directory, filepath.Join(workTree, path))
+		if err != nil {
+			return nil, err
+		}
+		ret[rel] = e
+	}
+
+	return ret, nil
+}
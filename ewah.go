@@ -0,0 +1,110 @@
+package gogitstatus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/bits"
+	"strconv"
+)
+
+// A bitmap compressed with the EWAH format, used by some of the Git index extensions.
+// See: https://github.com/git/git/blob/ef8ce8f3d4344fd3af049c17eeba5cd20d98b69f/ewah/ewok.h
+type EWAHBitmap struct {
+	BitSize uint32   // The amount of bits in the uncompressed bitmap
+	Words   []uint64 // The compressed words, a run-length word followed by its literal words, repeated
+}
+
+// https://github.com/git/git/blob/ef8ce8f3d4344fd3af049c17eeba5cd20d98b69f/ewah/ewok_rlw.h
+const ewahRunningLengthBits = 32
+const ewahLargestRunningLength = (1 << ewahRunningLengthBits) - 1
+
+// https://github.com/git/git/blob/ef8ce8f3d4344fd3af049c17eeba5cd20d98b69f/ewah/ewah_io.c
+func readEWAHBitmap(reader *bytes.Reader) (EWAHBitmap, error) {
+	var bitmap EWAHBitmap
+
+	fourBytes := make([]byte, 4)
+	if _, err := io.ReadFull(reader, fourBytes); err != nil {
+		return bitmap, errors.New("invalid size, unable to read EWAH bitmap bit size")
+	}
+	bitmap.BitSize = binary.BigEndian.Uint32(fourBytes)
+
+	if _, err := io.ReadFull(reader, fourBytes); err != nil {
+		return bitmap, errors.New("invalid size, unable to read EWAH bitmap word count")
+	}
+	wordCount := binary.BigEndian.Uint32(fourBytes)
+
+	// Don't trust the word count enough to allocate before we know the data is there
+	if uint64(wordCount)*8 > uint64(reader.Len()) {
+		return bitmap, errors.New("invalid size, EWAH bitmap word count " + strconv.FormatUint(uint64(wordCount), 10) + " is larger than the remaining data")
+	}
+
+	bitmap.Words = make([]uint64, wordCount)
+	eightBytes := make([]byte, 8)
+	for i := range bitmap.Words {
+		if _, err := io.ReadFull(reader, eightBytes); err != nil {
+			return bitmap, errors.New("invalid size, unable to read EWAH bitmap word")
+		}
+		bitmap.Words[i] = binary.BigEndian.Uint64(eightBytes)
+	}
+
+	// The position of the last run-length word, we don't need it for reading
+	if _, err := io.ReadFull(reader, fourBytes); err != nil {
+		return bitmap, errors.New("invalid size, unable to read EWAH bitmap run-length word position")
+	}
+
+	return bitmap, nil
+}
+
+// Calls fn with the position of every set bit in increasing order, until fn returns false.
+// https://github.com/git/git/blob/ef8ce8f3d4344fd3af049c17eeba5cd20d98b69f/ewah/ewah_bitmap.c
+func (bitmap EWAHBitmap) ForEachSetBit(fn func(position int) bool) {
+	position := 0
+	bitSize := int(bitmap.BitSize)
+
+	for i := 0; i < len(bitmap.Words); {
+		runningLengthWord := bitmap.Words[i]
+		i++
+
+		runningBit := runningLengthWord&1 != 0
+		runningLength := int((runningLengthWord >> 1) & ewahLargestRunningLength)
+		literalWords := int(runningLengthWord >> (1 + ewahRunningLengthBits))
+
+		if runningBit {
+			for j := 0; j < runningLength*64; j++ {
+				if position+j >= bitSize || !fn(position+j) {
+					return
+				}
+			}
+		}
+		position += runningLength * 64
+
+		for j := 0; j < literalWords && i < len(bitmap.Words); j++ {
+			word := bitmap.Words[i]
+			i++
+
+			for word != 0 {
+				bitPosition := position + bits.TrailingZeros64(word)
+				if bitPosition >= bitSize || !fn(bitPosition) {
+					return
+				}
+				word &= word - 1
+			}
+			position += 64
+		}
+	}
+}
+
+// Returns true if the bit at position is set
+func (bitmap EWAHBitmap) IsSet(position int) bool {
+	found := false
+	bitmap.ForEachSetBit(func(p int) bool {
+		if p >= position {
+			found = p == position
+			return false
+		}
+		return true
+	})
+	return found
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package gogitstatus
+
+import (
+	"os"
+	"syscall"
+)
+
+// Returns the ID of the device (filesystem) containing the file, or false if it is unknown
+func fileDevice(stat os.FileInfo) (uint64, bool) {
+	unixStat, ok := stat.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(unixStat.Dev), true
+}
@@ -0,0 +1,12 @@
+//go:build windows
+
+package gogitstatus
+
+import (
+	"os"
+)
+
+// Returns the ID of the device (filesystem) containing the file, or false if it is unknown
+func fileDevice(stat os.FileInfo) (uint64, bool) {
+	return 0, false
+}
@@ -3,8 +3,8 @@ module github.com/kivattt/gogitstatus
 go 1.21.5
 
 require (
-	github.com/botondmester/goignore v0.0.0-20260313111718-b1dbf095429d
 	golang.org/x/term v0.24.0
+	golang.org/x/text v0.18.0
 )
 
 require golang.org/x/sys v0.25.0 // indirect
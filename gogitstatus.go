@@ -3,13 +3,14 @@ package gogitstatus
 import (
 	"bytes"
 	"context"
-	"crypto/sha1"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"reflect"
 	"runtime"
@@ -18,8 +19,7 @@ import (
 	"sync"
 	"time"
 
-	//"github.com/sabhiram/go-gitignore"
-	ignore "github.com/botondmester/goignore"
+	"golang.org/x/text/unicode/norm"
 )
 
 // Debug output
@@ -30,25 +30,54 @@ var gogitstatus_debug_goroutine_slices = false
 var gogitstatus_debug_disable_skipdir = false
 var gogitstatus_debug_skipdir = false
 
-// A small subset of a Git index entry
+// The fields of a Git index entry needed for Status(), see GitIndexEntryFull for all of them
 type GitIndexEntry struct {
 	MetadataChangedTimeSeconds     uint32 // ctime
 	MetadataChangedTimeNanoSeconds uint32 // ctime
 	ModifiedTimeSeconds            uint32
 	ModifiedTimeNanoSeconds        uint32
-	Mode                           uint32   // Contains the file type and unix permission bits
-	FileSize                       uint32   // Size of the file in bytes from stat(2), truncated to 32-bit
-	Hash                           [20]byte // 20 bytes for the standard SHA-1
+	Mode                           uint32        // Contains the file type and unix permission bits
+	FileSize                       uint32        // Size of the file in bytes from stat(2), truncated to 32-bit
+	Hash                           []byte        // 20 bytes for SHA-1, 32 bytes for SHA-256
+	HashAlgorithm                  HashAlgorithm // The algorithm used for Hash
+	SkipWorktree                   bool          // Extended flag (version 3+), set by sparse-checkout or `git update-index --skip-worktree`
+	IntentToAdd                    bool          // Extended flag (version 3+), set by `git add -N`
+	AssumeValid                    bool          // Set by `git update-index --assume-unchanged`
+	Stage                          uint8         // 0 for a normal entry, 1 (common ancestor), 2 (ours) or 3 (theirs) for an unmerged entry
+
+	// The stages present for an unmerged path, only set in the map returned by ParseGitIndex() and ParseGitIndexFromMemory().
+	// Since the map is keyed by path, the entries of all the stages of a path are merged into one.
+	Conflict ConflictType
 }
 
+// A Git index entry with all of its fields, as returned by ParseGitIndexFull()
+type GitIndexEntryFull struct {
+	GitIndexEntry
+	Path          string
+	Device        uint32
+	Inode         uint32
+	UserID        uint32
+	GroupID       uint32
+	Flags         uint16 // The 16-bit flags field, including the assume-valid bit, the stage and the path name length
+	ExtendedFlags uint16 // The extended flags field, only present in version 3 and up when the extended bit is set in Flags
+}
+
+// https://github.com/git/git/blob/ef8ce8f3d4344fd3af049c17eeba5cd20d98b69f/read-cache.c
+const indexEntryAssumeValidFlag = 0x8000
+const indexEntryExtendedFlag = 0x4000
+const indexEntryStageMask = 0x3000
+const indexEntryStageShift = 12
+
+// The extended flags only exist in version 3 and up
+const indexEntrySkipWorktreeFlag = 0x4000
+const indexEntryIntentToAddFlag = 0x2000
+
 // This function is only used for path lengths in the .git/index longer than 0xffe bytes
+// entryLength is the length of the entry read so far, used to find the amount of null padding.
 // TODO: Can speed this up by first reading 0xfff bytes, and then 8 bytes at a time until the last byte of an 8-byte section is a null byte
-func readIndexEntryPathName(reader *bytes.Reader) (strings.Builder, error) {
+func readIndexEntryPathName(reader *bytes.Reader, entryLength int) (strings.Builder, error) {
 	var ret strings.Builder
 
-	// Entry length so far
-	entryLength := 40 + 20 + 2
-
 	// FIXME: Try to do this on the stack instead
 	singleByteSlice := make([]byte, 1)
 	for {
@@ -89,21 +118,133 @@ func readIndexEntryPathName(reader *bytes.Reader) (strings.Builder, error) {
 	return ret, nil
 }
 
+// Reads a variable-length integer as used for the path prefix compression in version 4
+// https://github.com/git/git/blob/ef8ce8f3d4344fd3af049c17eeba5cd20d98b69f/varint.c
+func readIndexVarint(reader *bytes.Reader) (uint64, error) {
+	c, err := reader.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	value := uint64(c & 127)
+	for c&128 != 0 {
+		value += 1
+		if value == 0 || value>>(64-7) != 0 {
+			return 0, errors.New("varint overflow")
+		}
+
+		c, err = reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+
+		value = (value << 7) + uint64(c&127)
+	}
+
+	return value, nil
+}
+
+// Reads a version 4 path name, which is stored as the number of bytes to remove from the end of the previous path name,
+// followed by the null-terminated suffix to append to it. There is no null padding.
+func readIndexEntryPrefixCompressedPathName(reader *bytes.Reader, previousPathName string) (strings.Builder, error) {
+	var ret strings.Builder
+
+	removeLength, err := readIndexVarint(reader)
+	if err != nil {
+		return ret, errors.New("invalid size, unable to read path prefix length: " + err.Error())
+	}
+
+	if removeLength > uint64(len(previousPathName)) {
+		return ret, errors.New("invalid path prefix length " + strconv.FormatUint(removeLength, 10) + ", the previous path name is only " + strconv.Itoa(len(previousPathName)) + " bytes")
+	}
+
+	ret.WriteString(previousPathName[:len(previousPathName)-int(removeLength)])
+
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return ret, errors.New("invalid size, unable to find null byte terminating the path name: " + err.Error())
+		}
+
+		if b == 0 {
+			break
+		}
+
+		ret.WriteByte(b)
+	}
+
+	return ret, nil
+}
+
+// Returned when a Git Index file is corrupt, e.g. truncated or not matching its checksum
+type IndexCorruptError struct {
+	Offset     int    // Byte offset into the index file where the problem was found
+	EntryIndex int    // Index of the entry where the problem was found, or -1 if it was outside of the entries
+	Reason     string // What is wrong
+}
+
+func (e *IndexCorruptError) Error() string {
+	if e.EntryIndex < 0 {
+		return "corrupt index at byte offset " + strconv.Itoa(e.Offset) + ": " + e.Reason
+	}
+	return "corrupt index at entry " + strconv.Itoa(e.EntryIndex) + ", byte offset " + strconv.Itoa(e.Offset) + ": " + e.Reason
+}
+
+// A Git index file, including its extensions
+type GitIndex struct {
+	Version       uint32
+	HashAlgorithm HashAlgorithm
+	Entries       []GitIndexEntryFull // In the order they appear in the index, sorted by path and then stage
+	Extensions    GitIndexExtensions
+}
+
 // Returns the relative paths mapping to the GitIndexEntry
-// Parses a Git Index file (version 2)
+// Parses a Git Index file (version 2, 3 or 4) of a repository using SHA-1
 func ParseGitIndex(ctx context.Context, path string) (map[string]GitIndexEntry, error) {
+	return ParseGitIndexWithHashAlgorithm(ctx, path, SHA1)
+}
+
+// Returns the relative paths mapping to the GitIndexEntry
+// Parses a Git Index file (version 2, 3 or 4), the hash algorithm can be found with ReadHashAlgorithm()
+func ParseGitIndexWithHashAlgorithm(ctx context.Context, path string, hashAlgorithm HashAlgorithm) (map[string]GitIndexEntry, error) {
+	var entries map[string]GitIndexEntry
+	err := withFileData(path, func(data []byte) error {
+		var err error
+		entries, err = ParseGitIndexFromMemoryWithHashAlgorithm(ctx, data, -1, hashAlgorithm)
+		return err
+	})
+
+	return entries, err
+}
+
+// Parses a Git Index file (version 2, 3 or 4) including its extensions, the hash algorithm can be found with ReadHashAlgorithm()
+// This is slower than ParseGitIndexWithHashAlgorithm(), use it only if you need the extensions.
+func ParseGitIndexFull(ctx context.Context, path string, hashAlgorithm HashAlgorithm) (*GitIndex, error) {
+	var index *GitIndex
+	err := withFileData(path, func(data []byte) error {
+		var err error
+		index, err = ParseGitIndexFullFromMemory(ctx, data, -1, hashAlgorithm)
+		return err
+	})
+
+	return index, err
+}
+
+// Calls fn with the contents of the regular file at path.
+// The data is only valid until fn returns, since it may be memory-mapped.
+func withFileData(path string, fn func(data []byte) error) error {
 	stat, err := os.Stat(path)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	if !stat.Mode().IsRegular() {
-		return nil, errors.New("not a regular file")
+		return errors.New("not a regular file")
 	}
 
 	file, err := os.Open(path)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer file.Close()
 
@@ -111,58 +252,122 @@ func ParseGitIndex(ctx context.Context, path string) (map[string]GitIndexEntry,
 	// because we're only reading 1 file here. But let's use it when we can
 	data, err := openFileData(file, stat)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer closeFileData(data)
 
-	return ParseGitIndexFromMemory(ctx, data, -1)
+	return fn(data)
 }
 
 // Returns the relative paths mapping to the GitIndexEntry
-// Parses a Git Index file (version 2)
+// Parses a Git Index file (version 2, 3 or 4) of a repository using SHA-1
 // Passing a negative value e.g. -1 to maxEntriesToPreAllocate means there will be no limit. Otherwise, we will only pre-allocate up to that many entries.
 func ParseGitIndexFromMemory(ctx context.Context, data []byte, maxEntriesToPreAllocate int) (map[string]GitIndexEntry, error) {
+	return ParseGitIndexFromMemoryWithHashAlgorithm(ctx, data, maxEntriesToPreAllocate, SHA1)
+}
+
+// Returns the relative paths mapping to the GitIndexEntry
+// Parses a Git Index file (version 2, 3 or 4), the hash algorithm can be found with ReadHashAlgorithm()
+// Passing a negative value e.g. -1 to maxEntriesToPreAllocate means there will be no limit. Otherwise, we will only pre-allocate up to that many entries.
+func ParseGitIndexFromMemoryWithHashAlgorithm(ctx context.Context, data []byte, maxEntriesToPreAllocate int, hashAlgorithm HashAlgorithm) (map[string]GitIndexEntry, error) {
+	entries, _, _, err := parseGitIndexEntriesFromMemory(ctx, data, maxEntriesToPreAllocate, hashAlgorithm, nil)
+	return entries, err
+}
+
+// Parses a Git Index file (version 2, 3 or 4) including its extensions, the hash algorithm can be found with ReadHashAlgorithm()
+// Passing a negative value e.g. -1 to maxEntriesToPreAllocate means there will be no limit. Otherwise, we will only pre-allocate up to that many entries.
+func ParseGitIndexFullFromMemory(ctx context.Context, data []byte, maxEntriesToPreAllocate int, hashAlgorithm HashAlgorithm) (*GitIndex, error) {
+	var entries []GitIndexEntryFull
+	_, version, entriesEndOffset, err := parseGitIndexEntriesFromMemory(ctx, data, maxEntriesToPreAllocate, hashAlgorithm, &entries)
+	if err != nil {
+		return nil, err
+	}
+
+	extensions, err := parseGitIndexExtensions(data, entriesEndOffset, hashAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GitIndex{
+		Version:       version,
+		HashAlgorithm: hashAlgorithm,
+		Entries:       entries,
+		Extensions:    extensions,
+	}, nil
+}
+
+// Parses the header and entries of a Git Index file, and verifies the trailing checksum.
+// Also returns the index version, and the offset into data right after the last entry (where the extensions begin).
+// If fullEntries is not nil, the entries are appended to it with all of their fields instead of being returned in the map.
+func parseGitIndexEntriesFromMemory(ctx context.Context, data []byte, maxEntriesToPreAllocate int, hashAlgorithm HashAlgorithm, fullEntries *[]GitIndexEntryFull) (entries map[string]GitIndexEntry, version uint32, entriesEndOffset int, err error) {
+	// The checksum is verified in parallel with parsing the entries.
+	// Errors found while parsing take priority, since they point more precisely to the problem.
+	checksumErrChan := make(chan error, 1)
+	go func() {
+		checksumErrChan <- verifyGitIndexChecksum(data, hashAlgorithm)
+	}()
+	defer func() {
+		checksumErr := <-checksumErrChan
+		if err == nil && checksumErr != nil {
+			entries, version, entriesEndOffset, err = nil, 0, 0, checksumErr
+		}
+	}()
+
 	reader := bytes.NewReader(data)
 
 	headerBytes := make([]byte, 12)
-	_, err := io.ReadFull(reader, headerBytes)
+	_, err = io.ReadFull(reader, headerBytes)
 	if err != nil {
-		return nil, err
+		return nil, 0, 0, &IndexCorruptError{Offset: 0, EntryIndex: -1, Reason: "invalid size, unable to read 12-byte header"}
 	}
 
 	if !bytes.HasPrefix(headerBytes, []byte{'D', 'I', 'R', 'C'}) {
-		return nil, errors.New("invalid header, missing \"DIRC\"")
+		return nil, 0, 0, &IndexCorruptError{Offset: 0, EntryIndex: -1, Reason: "invalid header, missing \"DIRC\""}
 	}
 
-	version := binary.BigEndian.Uint32(headerBytes[4:8])
-	if version != 2 {
-		return nil, errors.New("unsupported version: " + strconv.FormatInt(int64(version), 10))
+	version = binary.BigEndian.Uint32(headerBytes[4:8])
+	if version < 2 || version > 4 {
+		return nil, 0, 0, errors.New("unsupported version: " + strconv.FormatInt(int64(version), 10))
 	}
 
 	numEntries := binary.BigEndian.Uint32(headerBytes[8:12])
-	var entries map[string]GitIndexEntry
-	if maxEntriesToPreAllocate < 0 {
-		entries = make(map[string]GitIndexEntry, numEntries)
+	numEntriesToPreAllocate := numEntries
+	if maxEntriesToPreAllocate >= 0 {
+		numEntriesToPreAllocate = min(uint32(maxEntriesToPreAllocate), numEntries)
+	}
+	if fullEntries != nil {
+		*fullEntries = make([]GitIndexEntryFull, 0, numEntriesToPreAllocate)
 	} else {
-		entries = make(map[string]GitIndexEntry, min(uint32(maxEntriesToPreAllocate), numEntries))
+		entries = make(map[string]GitIndexEntry, numEntriesToPreAllocate)
 	}
 
+	// The hashes of all the entries are stored in larger allocations, instead of 1 allocation per entry
+	hashSize := hashAlgorithm.Size()
+	hashStorage := make([]byte, int(numEntriesToPreAllocate)*hashSize)
+
 	flagsBytes := make([]byte, 2)         // 16 bits 'flags' field
 	modeBytes := make([]byte, 4)          // 32 bits
 	fileSizeBytes := make([]byte, 4)      // 32 bits
 	eightBytes := make([]byte, 8)         // 64 bits
-	hashBytes := make([]byte, 20)         // 160 bits
 	pathNameBuffer := make([]byte, 0xffe) // We allocate enough for the largest possible known-size (not null-terminated) Git path name length.
+	previousPathName := ""                // Used for the path prefix compression in version 4
 
 	var entryIndex uint32
+	entryOffset := 0 // Byte offset of the start of the current entry
+	corrupt := func(offset int, reason string) error {
+		return &IndexCorruptError{Offset: offset, EntryIndex: int(entryIndex), Reason: reason}
+	}
+
 	for entryIndex = 0; entryIndex < numEntries; entryIndex++ {
 		select {
 		case <-ctx.Done():
-			return nil, ctx.Err()
+			return nil, 0, 0, ctx.Err()
 		default:
+			entryOffset = len(data) - reader.Len()
+
 			// Read 64-bit metadata changed time (ctime)
 			if _, err := io.ReadFull(reader, eightBytes); err != nil {
-				return nil, errors.New("invalid size, unable to read 64-bit metadata changed time (ctime) within entry at index " + strconv.FormatInt(int64(entryIndex), 10))
+				return nil, 0, 0, corrupt(entryOffset, "invalid size, unable to read 64-bit metadata changed time (ctime)")
 			}
 
 			ctimeSeconds := binary.BigEndian.Uint32(eightBytes[:4])
@@ -170,62 +375,94 @@ func ParseGitIndexFromMemory(ctx context.Context, data []byte, maxEntriesToPreAl
 
 			// Read 64-bit modified time (mTime)
 			if _, err := io.ReadFull(reader, eightBytes); err != nil {
-				return nil, errors.New("invalid size, unable to read 64-bit modified time within entry at index " + strconv.FormatInt(int64(entryIndex), 10))
+				return nil, 0, 0, corrupt(entryOffset+8, "invalid size, unable to read 64-bit modified time")
 			}
 
 			mTimeSeconds := binary.BigEndian.Uint32(eightBytes[:4])
 			mTimeNanoSeconds := binary.BigEndian.Uint32(eightBytes[4:])
 
-			// Seek to 32-bit mode
-			if _, err := reader.Seek(8, 1); err != nil { // 64 bits
-				return nil, errors.New("invalid size, unable to seek to 32-bit mode within entry at index " + strconv.FormatInt(int64(entryIndex), 10))
+			// Read 32-bit device and 32-bit inode
+			if _, err := io.ReadFull(reader, eightBytes); err != nil {
+				return nil, 0, 0, corrupt(entryOffset+16, "invalid size, unable to read 32-bit device and 32-bit inode")
 			}
 
+			device := binary.BigEndian.Uint32(eightBytes[:4])
+			inode := binary.BigEndian.Uint32(eightBytes[4:])
+
 			// Read 32-bit mode
 			if _, err := io.ReadFull(reader, modeBytes); err != nil {
-				return nil, errors.New("invalid size, unable to read 32-bit mode within entry at index " + strconv.FormatInt(int64(entryIndex), 10))
+				return nil, 0, 0, corrupt(entryOffset+24, "invalid size, unable to read 32-bit mode")
 			}
 
 			mode := binary.BigEndian.Uint32(modeBytes)
 
-			// Seek to 32-bit file size
-			if _, err := reader.Seek(8, 1); err != nil { // 64 bits
-				return nil, errors.New("invalid size, unable to seek to 32-bit file size within entry at index " + strconv.FormatInt(int64(entryIndex), 10))
+			// Read 32-bit user id and 32-bit group id
+			if _, err := io.ReadFull(reader, eightBytes); err != nil {
+				return nil, 0, 0, corrupt(entryOffset+28, "invalid size, unable to read 32-bit user id and 32-bit group id")
 			}
 
+			userID := binary.BigEndian.Uint32(eightBytes[:4])
+			groupID := binary.BigEndian.Uint32(eightBytes[4:])
+
 			// Read 32-bit file size
 			if _, err := io.ReadFull(reader, fileSizeBytes); err != nil {
-				return nil, errors.New("invalid size, unable to read 32-bit mode within entry at index " + strconv.FormatInt(int64(entryIndex), 10))
+				return nil, 0, 0, corrupt(entryOffset+36, "invalid size, unable to read 32-bit file size")
 			}
 
 			fileSize := binary.BigEndian.Uint32(fileSizeBytes)
 
 			// Read hash data
+			if len(hashStorage) < hashSize {
+				hashStorage = make([]byte, 256*hashSize)
+			}
+			hashBytes := hashStorage[:hashSize:hashSize]
+			hashStorage = hashStorage[hashSize:]
+
 			if _, err := io.ReadFull(reader, hashBytes); err != nil {
-				return nil, errors.New("invalid size, unable to read 20-byte SHA-1 hash at index " + strconv.FormatUint(uint64(entryIndex), 10))
+				return nil, 0, 0, corrupt(entryOffset+40, "invalid size, unable to read "+strconv.Itoa(hashSize)+"-byte "+hashAlgorithm.String()+" hash")
 			}
 
 			if _, err := io.ReadFull(reader, flagsBytes); err != nil {
-				return nil, errors.New("invalid size, unable to read 2-byte flags field at index " + strconv.FormatUint(uint64(entryIndex), 10))
+				return nil, 0, 0, corrupt(entryOffset+40+hashSize, "invalid size, unable to read 2-byte flags field")
 			}
 
 			flags := binary.BigEndian.Uint16(flagsBytes)
 			nameLength := flags & 0xfff
 
+			entryLength := 40 + hashSize + 2 // Entry length so far
+
+			var extendedFlags uint16
+			if flags&indexEntryExtendedFlag != 0 {
+				if version < 3 {
+					return nil, 0, 0, corrupt(entryOffset+40+hashSize, "extended flag set in version "+strconv.FormatUint(uint64(version), 10)+" entry")
+				}
+
+				if _, err := io.ReadFull(reader, flagsBytes); err != nil {
+					return nil, 0, 0, corrupt(entryOffset+entryLength, "invalid size, unable to read 2-byte extended flags field")
+				}
+
+				extendedFlags = binary.BigEndian.Uint16(flagsBytes)
+				entryLength += 2
+			}
+
 			var pathName strings.Builder // TODO: Do we really want this to be a string builder? Might be faster to avoid it entirely?
-			if nameLength == 0xfff {     // Path name length >= 0xfff, need to manually find null bytes
+			if version == 4 {
+				pathName, err = readIndexEntryPrefixCompressedPathName(reader, previousPathName)
+				if err != nil {
+					return nil, 0, 0, corrupt(entryOffset+entryLength, err.Error())
+				}
+			} else if nameLength == 0xfff { // Path name length >= 0xfff, need to manually find null bytes
 				// Read variable-length path name
-				pathName, err = readIndexEntryPathName(reader)
+				pathName, err = readIndexEntryPathName(reader, entryLength)
 				if err != nil {
-					return nil, err
+					return nil, 0, 0, corrupt(entryOffset+entryLength, err.Error())
 				}
 			} else {
 				if _, err := io.ReadFull(reader, pathNameBuffer[:nameLength]); err != nil {
-					return nil, errors.New("invalid size, unable to read path name of size " + strconv.FormatUint(uint64(nameLength), 10) + " at index " + strconv.FormatUint(uint64(entryIndex), 10))
+					return nil, 0, 0, corrupt(entryOffset+entryLength, "invalid size, unable to read path name of size "+strconv.FormatUint(uint64(nameLength), 10))
 				}
 
 				pathName.Write(pathNameBuffer[:nameLength])
-				entryLength := 40 + 20 + 2 // Entry length so far
 				// Read up to 8 null padding bytes
 				n := 8 - ((int(nameLength) + entryLength) % 8)
 				if n == 0 {
@@ -233,29 +470,81 @@ func ParseGitIndexFromMemory(ctx context.Context, data []byte, maxEntriesToPreAl
 				}
 
 				if _, err = io.ReadFull(reader, eightBytes[:n]); err != nil {
-					return nil, errors.New("invalid size, unable to read path name null bytes of size " + strconv.FormatUint(uint64(n), 10) + " at index " + strconv.FormatUint(uint64(entryIndex), 10))
+					return nil, 0, 0, corrupt(entryOffset+entryLength+int(nameLength), "invalid size, unable to read path name null bytes of size "+strconv.FormatUint(uint64(n), 10))
 				}
 
 				for _, e := range eightBytes[:n] {
 					if e != 0 {
-						return nil, errors.New("non-null byte found in null padding of length " + strconv.FormatUint(uint64(n), 10))
+						return nil, 0, 0, corrupt(entryOffset+entryLength+int(nameLength), "non-null byte found in null padding of length "+strconv.FormatUint(uint64(n), 10))
 					}
 				}
 			}
 
-			entries[pathName.String()] = GitIndexEntry{
+			previousPathName = pathName.String()
+			entry := GitIndexEntry{
 				MetadataChangedTimeSeconds:     ctimeSeconds,
 				MetadataChangedTimeNanoSeconds: ctimeNanoSeconds,
 				ModifiedTimeSeconds:            mTimeSeconds,
 				ModifiedTimeNanoSeconds:        mTimeNanoSeconds,
 				Mode:                           mode,
 				FileSize:                       fileSize,
-				Hash:                           [20]byte(hashBytes),
+				Hash:                           hashBytes,
+				HashAlgorithm:                  hashAlgorithm,
+				SkipWorktree:                   extendedFlags&indexEntrySkipWorktreeFlag != 0,
+				IntentToAdd:                    extendedFlags&indexEntryIntentToAddFlag != 0,
+				AssumeValid:                    flags&indexEntryAssumeValidFlag != 0,
+				Stage:                          uint8((flags & indexEntryStageMask) >> indexEntryStageShift),
+			}
+
+			if fullEntries != nil {
+				*fullEntries = append(*fullEntries, GitIndexEntryFull{
+					GitIndexEntry: entry,
+					Path:          previousPathName,
+					Device:        device,
+					Inode:         inode,
+					UserID:        userID,
+					GroupID:       groupID,
+					Flags:         flags,
+					ExtendedFlags: extendedFlags,
+				})
+			} else {
+				addGitIndexEntryToMap(entries, previousPathName, entry)
 			}
 		}
 	}
 
-	return entries, nil
+	return entries, version, len(data) - reader.Len(), nil
+}
+
+// The map is keyed by path only, so the stages of an unmerged path are merged into a single entry with Conflict set
+func addGitIndexEntryToMap(entries map[string]GitIndexEntry, path string, entry GitIndexEntry) {
+	if entry.Stage != 0 {
+		entry.Conflict = entries[path].Conflict | 1<<(entry.Stage-1)
+	}
+	entries[path] = entry
+}
+
+// Verifies the checksum at the end of a Git Index file, which is a hash of all the data before it.
+// A checksum of all zeroes is accepted, since Git writes that when index.skipHash is enabled.
+func verifyGitIndexChecksum(data []byte, hashAlgorithm HashAlgorithm) error {
+	hashSize := hashAlgorithm.Size()
+	checksumOffset := len(data) - hashSize
+	if checksumOffset < 12 {
+		return &IndexCorruptError{Offset: max(checksumOffset, 0), EntryIndex: -1, Reason: "invalid size, no room for the trailing checksum"}
+	}
+
+	checksum := data[checksumOffset:]
+	if isZeroHash(checksum) {
+		return nil
+	}
+
+	hasher := hashAlgorithm.new()
+	hasher.Write(data[:checksumOffset])
+	if !bytes.Equal(hasher.Sum(nil), checksum) {
+		return &IndexCorruptError{Offset: checksumOffset, EntryIndex: -1, Reason: "checksum mismatch, the " + hashAlgorithm.String() + " hash of the index data is not " + hex.EncodeToString(checksum)}
+	}
+
+	return nil
 }
 
 /*func convertLFToCRLF(data []byte) []byte {
@@ -296,8 +585,8 @@ func convertCRLFToLF(data []byte) []byte {
 	return out
 }
 
-func hashMatchesFileOrWithLineEndingConvertedHack(hash []byte, path string, stat os.FileInfo) bool {
-	if hashMatchesFile(hash, path, stat) {
+func hashMatchesFileOrWithLineEndingConvertedHack(hashAlgorithm HashAlgorithm, hash []byte, path string, stat os.FileInfo) bool {
+	if hashMatchesFile(hashAlgorithm, hash, path, stat) {
 		return true
 	}
 
@@ -318,19 +607,20 @@ func hashMatchesFileOrWithLineEndingConvertedHack(hash []byte, path string, stat
 	defer closeFileData(data)
 
 	crlf := convertCRLFToLF(data)
-	return hashMatches(hash, crlf)
+	return hashMatches(hashAlgorithm, hash, crlf)
 }
 
-func hashMatchesFile(hash []byte, path string, stat os.FileInfo) bool {
+func hashMatchesFile(hashAlgorithm HashAlgorithm, hash []byte, path string, stat os.FileInfo) bool {
 	// Symlinks are hashed with the target path, not the data of the target file
-	// On Windows, symlinks are stored as regular files (with target path as the file data), so we handle them as such later
-	if runtime.GOOS != "windows" && stat.Mode()&os.ModeSymlink != 0 /*|| !stat.Mode().IsRegular()*/ {
+	// This depends on what is on disk, not on core.symlinks, like ce_compare_link() in Git.
+	// With core.symlinks=false (the default on Windows), symlinks are checked out as regular files with the target path as the file data, so we handle them as such later
+	if stat.Mode()&os.ModeSymlink != 0 /*|| !stat.Mode().IsRegular()*/ {
 		targetPath, err := os.Readlink(path)
 		if err != nil {
 			return false
 		}
 
-		return hashMatches(hash, []byte(targetPath))
+		return hashMatches(hashAlgorithm, hash, []byte(targetPath))
 	}
 
 	file, err := os.Open(path)
@@ -345,11 +635,11 @@ func hashMatchesFile(hash []byte, path string, stat os.FileInfo) bool {
 	}
 	defer closeFileData(data)
 
-	return hashMatches(hash, data)
+	return hashMatches(hashAlgorithm, hash, data)
 }
 
-func hashMatches(hash, data []byte) bool {
-	newHash := sha1.New()
+func hashMatches(hashAlgorithm HashAlgorithm, hash, data []byte) bool {
+	newHash := hashAlgorithm.new()
 	_, err := newHash.Write(append([]byte("blob "+strconv.FormatInt(int64(len(data)), 10)), 0))
 	if err != nil {
 		return false
@@ -363,7 +653,7 @@ func hashMatches(hash, data []byte) bool {
 	return reflect.DeepEqual(hash, newHash.Sum(nil))
 }
 
-type WhatChanged uint8
+type WhatChanged uint16
 
 const (
 	// https://github.com/git/git/blob/ef8ce8f3d4344fd3af049c17eeba5cd20d98b69f/statinfo.h#L35
@@ -375,7 +665,8 @@ const (
 	DATA_CHANGED  WhatChanged = 0x0020
 	TYPE_CHANGED  WhatChanged = 0x0040
 
-	DELETED = 0x0080
+	DELETED       = 0x0080
+	INTENT_TO_ADD = 0x0100 // Added with `git add -N`, shown as "new file" by `git status`
 )
 
 var whatChangedToStringMap = map[WhatChanged]string{
@@ -387,7 +678,8 @@ var whatChangedToStringMap = map[WhatChanged]string{
 	DATA_CHANGED:  "DATA_CHANGED",
 	TYPE_CHANGED:  "TYPE_CHANGED",
 
-	DELETED: "DELETED",
+	DELETED:       "DELETED",
+	INTENT_TO_ADD: "INTENT_TO_ADD",
 }
 
 var stringToWhatChangedMap = map[string]WhatChanged{
@@ -399,7 +691,8 @@ var stringToWhatChangedMap = map[string]WhatChanged{
 	"DATA_CHANGED":  DATA_CHANGED,
 	"TYPE_CHANGED":  TYPE_CHANGED,
 
-	"DELETED": DELETED,
+	"DELETED":       DELETED,
+	"INTENT_TO_ADD": INTENT_TO_ADD,
 }
 
 func WhatChangedToString(whatChanged WhatChanged) string {
@@ -428,11 +721,12 @@ const OBJECT_TYPE_MASK = 0b1111 << 12
 const REGULAR_FILE = 0b1000 << 12
 const SYMBOLIC_LINK = 0b1010 << 12
 const GITLINK = 0b1110 << 12
+const DIRECTORY = 0b0100 << 12 // Only used for the directory entries of a sparse index
 
 // Returns 0 if the file is unchanged.
 // If you pass this a nil value for stat, it will return 0.
 // https://github.com/git/git/blob/ef8ce8f3d4344fd3af049c17eeba5cd20d98b69f/read-cache.c#L307
-func fileChanged(entry GitIndexEntry, entryFullPath string, stat os.FileInfo) WhatChanged {
+func fileChanged(entry GitIndexEntry, entryFullPath string, stat os.FileInfo, settings *statusSettings) WhatChanged {
 	if stat == nil {
 		return 0 // Deleted file
 	}
@@ -454,16 +748,15 @@ func fileChanged(entry GitIndexEntry, entryFullPath string, stat os.FileInfo) Wh
 		}
 
 		// https://github.com/git/git/blob/ef8ce8f3d4344fd3af049c17eeba5cd20d98b69f/read-cache.c#L317
-		// Windows only stores the mode permission bits in .git/index, not on disk
-		if runtime.GOOS != "windows" && fs.FileMode(entry.Mode)&fs.ModePerm&0100 != stat.Mode()&fs.ModePerm&0100 {
+		if settings.fileMode && fs.FileMode(entry.Mode)&fs.ModePerm&0100 != stat.Mode()&fs.ModePerm&0100 {
 			whatChanged |= MODE_CHANGED
 		}
 	case SYMBOLIC_LINK:
-		// Symbolic links are stored as regular files on Windows
-		if runtime.GOOS != "windows" && stat.Mode()&os.ModeSymlink == 0 /*&& !stat.Mode().IsRegular()*/ {
+		// Symbolic links are stored as regular files with core.symlinks=false (the default on Windows)
+		if stat.Mode()&os.ModeSymlink == 0 && (settings.symlinks || !stat.Mode().IsRegular()) {
 			whatChanged |= TYPE_CHANGED
 		}
-	case GITLINK:
+	case GITLINK, DIRECTORY:
 		if !stat.IsDir() {
 			whatChanged |= TYPE_CHANGED
 		}
@@ -476,59 +769,82 @@ func fileChanged(entry GitIndexEntry, entryFullPath string, stat os.FileInfo) Wh
 
 	if entry.FileSize != uint32(stat.Size()) {
 		whatChanged |= DATA_CHANGED
-	} else if !hashMatchesFileOrWithLineEndingConvertedHack(entry.Hash[:], entryFullPath, stat) {
+	} else if !hashMatchesFileOrWithLineEndingConvertedHack(entry.HashAlgorithm, entry.Hash, entryFullPath, stat) {
 		whatChanged |= DATA_CHANGED
 	}
 
 	return whatChanged
 }
 
-type ChangedFile struct {
-	WhatChanged WhatChanged
-	Untracked   bool // true = Untracked, false = Unstaged
-}
+// The kind of merge conflict for an unmerged path, which depends on the stages present in the index.
+// Same as the "unmerged" states shown by `git status`.
+// https://github.com/git/git/blob/ef8ce8f3d4344fd3af049c17eeba5cd20d98b69f/wt-status.c
+type ConflictType uint8
 
-func ignoreMatch(path string, ignoresMap map[string]*ignore.GitIgnore) bool {
-	// TODO: Use strings.indexByte directly without temporary storage to speed up filepath.Dir()?
+const (
+	// Bit 1 << (stage-1) is set for each stage present
+	BOTH_DELETED    ConflictType = 0b001 // DD, only the common ancestor
+	ADDED_BY_US     ConflictType = 0b010 // AU
+	DELETED_BY_THEM ConflictType = 0b011 // UD
+	ADDED_BY_THEM   ConflictType = 0b100 // UA
+	DELETED_BY_US   ConflictType = 0b101 // DU
+	BOTH_ADDED      ConflictType = 0b110 // AA
+	BOTH_MODIFIED   ConflictType = 0b111 // UU
+)
 
-	dir := path
-	// We hint something is a folder with a trailing '/', so remove it to get the real parent folder
-	if dir[len(dir)-1] == '/' {
-		dir = dir[:len(dir)-1]
-	}
-	dir = filepath.Dir(dir)
+var conflictTypeToStringMap = map[ConflictType]string{
+	BOTH_DELETED:    "BOTH_DELETED",
+	ADDED_BY_US:     "ADDED_BY_US",
+	DELETED_BY_THEM: "DELETED_BY_THEM",
+	ADDED_BY_THEM:   "ADDED_BY_THEM",
+	DELETED_BY_US:   "DELETED_BY_US",
+	BOTH_ADDED:      "BOTH_ADDED",
+	BOTH_MODIFIED:   "BOTH_MODIFIED",
+}
 
-	for {
-		// Faster than filepath.Rel()
-		var rel string
-		if dir == "." {
-			rel = path
-		} else {
-			rel = path[len(dir)+1:]
-		}
+// Returns an empty string if conflictType is not a conflict
+func ConflictTypeToString(conflictType ConflictType) string {
+	return conflictTypeToStringMap[conflictType]
+}
 
-		if rel == "" {
-			panic("unreachable")
+// Returns 0 if text is not a conflict type
+func StringToConflictType(text string) ConflictType {
+	for k, v := range conflictTypeToStringMap {
+		if v == text {
+			return k
 		}
+	}
+	return 0
+}
 
-		ignore, ok := ignoresMap[dir]
-		if ok {
-			// We don't need to do filepath.ToSlash(rel), since it's done inside goignore.
-			if ignore.MatchesPath(rel) {
-				return true
-			}
-		}
+type ChangedFile struct {
+	WhatChanged WhatChanged
+	Untracked   bool         // true = Untracked, false = Unstaged
+	Conflict    ConflictType // Non-zero for an unmerged path, WhatChanged is 0 in that case
+	Ignored     bool         // An ignored file or folder, only reported by StatusRepositoryWithIgnored(). Untracked is false in that case
+}
 
-		// Debugging
-		//fmt.Println("dir lookup:", dir, " (cached gitignore? " + bool2Str(ok) + ")")
+// How ignored files are reported, same as `git status --ignored=<mode>`
+type IgnoredMode uint8
 
-		// Reached root path without any match
-		if dir == "." {
-			return false
-		}
+const (
+	IGNORED_NO          IgnoredMode = iota // Ignored files are not reported
+	IGNORED_TRADITIONAL                    // Ignored files, folders only containing ignored files are reported as a single entry
+	IGNORED_MATCHING                       // Ignored files and folders matching an ignore pattern, the contents of ignored folders are not reported
+)
 
-		dir = filepath.Dir(dir)
+// Lowercases the ASCII letters of path, the same case-folding Git uses with core.ignoreCase
+func foldCase(path string) string {
+	for i := 0; i < len(path); i++ {
+		if path[i] >= 'A' && path[i] <= 'Z' {
+			folded := []byte(path)
+			for j := i; j < len(folded); j++ {
+				folded[j] = asciiToLower(folded[j])
+			}
+			return string(folded)
+		}
 	}
+	return path
 }
 
 // Walks the directory at path, returning a list of all the relative file paths.
@@ -618,9 +934,30 @@ func skipDir(paths []string, index int) (int, error) {
 	return errorIndex, errors.New("reached the end of paths")
 }
 
-func untrackedPathsNotIgnoredWorker(ctx context.Context, paths []string, ignoresCache map[string]*ignore.GitIgnore, indexEntries map[string]GitIndexEntry, gitLinkPaths []string, respectGitIgnore bool) map[string]ChangedFile {
+// The keys of indexEntries and trackedFolders, and the paths in gitLinkPaths, are expected to be normalized with settings.normalizePath()
+// With ignoredMode, ignored files and folders matching an ignore pattern are also returned.
+// trackedFolders are the folders containing tracked files, which are never reported as ignored.
+func untrackedPathsNotIgnoredWorker(ctx context.Context, paths []string, rules *ignoreRules, indexEntries map[string]GitIndexEntry, gitLinkPaths []string, respectGitIgnore bool, settings *statusSettings, ignoredMode IgnoredMode, trackedFolders map[string]bool) map[string]ChangedFile {
 	out := make(map[string]ChangedFile)
 
+	// Whether the folders containing the paths are ignored, so every path doesn't check all of its parent folders again
+	ignoredFolders := make(map[string]bool)
+
+	// Ignored folders without tracked files are reported as a single entry, folder is "/"-separated
+	collapsedFolders := make(map[string]bool)
+	isCollapsedFolder := func(folder string) bool {
+		if folder == "." {
+			return false
+		}
+
+		collapsed, ok := collapsedFolders[folder]
+		if !ok {
+			collapsed = !trackedFolders[settings.normalizePath(folder)] && rules.isIgnored(folder, true, ignoredFolders)
+			collapsedFolders[folder] = collapsed
+		}
+		return collapsed
+	}
+
 	// We can not use `for i := range paths` here, because then we wouldn't be able to reassign the index variable i.
 loop:
 	for i := 0; i < len(paths); i++ {
@@ -631,17 +968,23 @@ loop:
 			// Path relative to the repository folder e.g. "src/file.cpp"
 			rel := paths[i]
 
+			// The "/"-separated path used for lookups, like Git does with core.ignoreCase and core.precomposeUnicode
+			lookupPath := filepath.ToSlash(settings.normalizePath(rel))
+
+			// The "/"-separated path matched against ignore patterns, which handle core.ignoreCase themselves
+			matchPath := filepath.ToSlash(strings.TrimSuffix(settings.precomposePath(rel), "/"))
+
 			// If it's in the .git/index, it's tracked
-			_, tracked := indexEntries[filepath.ToSlash(rel)]
+			_, tracked := indexEntries[lookupPath]
 			if tracked {
 				continue
 			}
 
-			// Skip submodules (gitlinks)
+			// Skip submodules (gitlinks) and sparse directories
 			for _, path := range gitLinkPaths {
-				if strings.HasPrefix(rel, path) {
+				if strings.HasPrefix(lookupPath, path) {
 					if gogitstatus_debug_ignored {
-						fmt.Println("IGNORED (because submodule/gitlink):", rel)
+						fmt.Println("IGNORED (because submodule/gitlink or sparse directory):", rel)
 					}
 					continue loop
 				}
@@ -650,15 +993,27 @@ loop:
 			isDir := rel[len(rel)-1] == '/' // We added this '/' manually in getPathsRecursivelyRelativeTo(), so no cross-platform worries.
 
 			// Don't add ignored files
-			if respectGitIgnore && ignoreMatch(rel, ignoresCache) {
+			if respectGitIgnore && rules.isIgnored(matchPath, isDir, ignoredFolders) {
 				if gogitstatus_debug_ignored {
 					fmt.Println("IGNORED:", rel)
 				}
 
+				// The contents of a collapsed folder are not reported, only the topmost collapsed folder is
+				collapsed := false
+				if ignoredMode != IGNORED_NO && !isCollapsedFolder(path.Dir(matchPath)) {
+					if !isDir {
+						out[rel] = ChangedFile{Ignored: true}
+					} else if isCollapsedFolder(matchPath) {
+						out[rel[:len(rel)-1]] = ChangedFile{Ignored: true}
+						collapsed = true
+					}
+				}
+
 				// Skip ignored directories.
 				// This is not strictly necessary since we always check
-				// if any parent folders are ignored, but it avoids unnecessary work
-				if isDir && !gogitstatus_debug_disable_skipdir {
+				// if any parent folders are ignored, but it avoids unnecessary work.
+				// Folders with tracked files may contain ignored files to report.
+				if isDir && (ignoredMode == IGNORED_NO || collapsed) && !gogitstatus_debug_disable_skipdir {
 					var err error
 					if gogitstatus_debug_skipdir {
 						fmt.Println("SKIPPING FROM:", paths[i])
@@ -688,45 +1043,86 @@ loop:
 
 // Returns untracked files that aren't ignored.
 // It recursively iterates through the directory path, ignoring files/directories named ".git" and files ignored by .gitignore
-func untrackedPathsNotIgnored(ctx context.Context, paths []string, gitIgnorePaths []string, path string, indexEntries map[string]GitIndexEntry, respectGitIgnore bool, numCPUs int) (map[string]ChangedFile, error) {
+// excludesPaths are ignore files like $GIT_DIR/info/exclude that apply to the whole repository, in order of increasing precedence.
+// They have a lower precedence than the .gitignore files.
+// With settings.ignoreCase and settings.precomposeUnicode, paths are normalized before comparing them to the index and .gitignore patterns.
+// With ignoredMode, ignored files and folders are also returned.
+func untrackedPathsNotIgnored(ctx context.Context, paths []string, gitIgnorePaths []string, excludesPaths []string, path string, indexEntries map[string]GitIndexEntry, respectGitIgnore bool, settings *statusSettings, ignoredMode IgnoredMode, numCPUs int) (map[string]ChangedFile, error) {
 	absPath, err := filepath.Abs(path)
 	if err == nil {
 		path = absPath
 	}
 
 	start := time.Now()
-	// Compile all the .gitignore files we found during the directory walk
-	ignoresCache := make(map[string]*ignore.GitIgnore)
+	// Parse all the .gitignore files we found during the directory walk, unreadable ones are skipped like in Git
+	rules := &ignoreRules{folders: make(map[string]*ignoreFile), ignoreCase: settings.ignoreCase}
 	if respectGitIgnore {
+		rules.excludes = readExcludesFiles(excludesPaths, settings)
+
 		for _, gitIgnorePath := range gitIgnorePaths {
-			ignore, err := ignore.CompileIgnoreFile(gitIgnorePath)
+			// The root folder is "", the others are "/"-separated and relative to path
+			folder := settings.precomposePath(filepath.ToSlash(filepath.Dir(gitIgnorePath[len(path)+1:])))
+			base := folder + "/"
+			if folder == "." {
+				folder = ""
+				base = ""
+			}
+
+			file, err := readIgnoreFile(gitIgnorePath, base, true, settings)
 			if err == nil {
-				// The root folder key ends up being "." in the ignoresCache
-				// because filepath.Dir("") == "."
-				pathLookup := filepath.Dir(gitIgnorePath[len(path)+1:])
-				ignoresCache[pathLookup] = ignore
+				rules.folders[folder] = file
 			}
 		}
 	}
+
+	// Case-insensitive or normalization-insensitive lookups of tracked paths
+	if settings.ignoreCase || settings.precomposeUnicode {
+		normalizedIndexEntries := make(map[string]GitIndexEntry, len(indexEntries))
+		for path, entry := range indexEntries {
+			normalizedIndexEntries[settings.normalizePath(path)] = entry
+		}
+		indexEntries = normalizedIndexEntries
+	}
 	if gogitstatus_debug_profiling {
 		fmt.Println("Compiling gitignore:", time.Since(start))
 	}
 
 	start = time.Now()
-	// Submodule / gitlink paths. They all end in a path separator to signify being a folder
+	// Submodule / gitlink paths, and the directory entries of a sparse index. They all end in a "/" to signify being a folder
 	// PERF: This could be moved to happen right after ParseGitIndex() and then pass gitLinksPath to this function
 	// That way we save the ~8 milliseconds this takes (on chromium repo)
 	// by putting it where we're already waiting on a serial dependency to finish (walking the filesystem).
 	gitLinkPaths := make([]string, 0)
 	for path, entry := range indexEntries {
 		if (entry.Mode & OBJECT_TYPE_MASK) == GITLINK {
-			gitLinkPaths = append(gitLinkPaths, path+string(os.PathSeparator))
+			gitLinkPaths = append(gitLinkPaths, path+"/")
+		} else if (entry.Mode & OBJECT_TYPE_MASK) == DIRECTORY {
+			// The files inside are tracked, we don't read tree objects to know which ones
+			gitLinkPaths = append(gitLinkPaths, path)
 		}
 	}
 	if gogitstatus_debug_profiling {
 		fmt.Println("Creating list of gitlinks:", time.Since(start))
 	}
 
+	// Folders containing tracked files, including gitlinks, are never reported as ignored
+	trackedFolders := make(map[string]bool)
+	if ignoredMode != IGNORED_NO {
+		for path, entry := range indexEntries {
+			if (entry.Mode & OBJECT_TYPE_MASK) == GITLINK {
+				trackedFolders[strings.TrimSuffix(path, "/")] = true
+			}
+
+			for folder := path; strings.Contains(folder, "/"); {
+				folder = folder[:strings.LastIndexByte(folder, '/')]
+				if trackedFolders[folder] {
+					break
+				}
+				trackedFolders[folder] = true
+			}
+		}
+	}
+
 	start = time.Now()
 	slices := spreadArrayIntoSlicesForGoroutines(len(paths), numCPUs)
 	results := make([]map[string]ChangedFile, numCPUs)
@@ -739,7 +1135,7 @@ func untrackedPathsNotIgnored(ctx context.Context, paths []string, gitIgnorePath
 		}
 		go func(threadIdx int, slice sliceType) {
 			ourSlice := paths[slice.start : slice.start+slice.length]
-			results[threadIdx] = untrackedPathsNotIgnoredWorker(ctx, ourSlice, ignoresCache, indexEntries, gitLinkPaths, respectGitIgnore)
+			results[threadIdx] = untrackedPathsNotIgnoredWorker(ctx, ourSlice, rules, indexEntries, gitLinkPaths, respectGitIgnore, settings, ignoredMode, trackedFolders)
 			wg.Done()
 		}(threadIdx, slice)
 	}
@@ -764,10 +1160,79 @@ func untrackedPathsNotIgnored(ctx context.Context, paths []string, gitIgnorePath
 			fmt.Println("Merge results:", time.Since(start))
 		}
 
+		if ignoredMode == IGNORED_TRADITIONAL {
+			collapseIgnoredFolders(paths, results[0])
+		}
+
 		return results[0], nil
 	}
 }
 
+// Replaces the contents of folders only containing ignored files with a single ignored entry for the folder, like `git status --ignored=traditional`.
+// Empty folders are not taken into account, since Git doesn't track them.
+// paths are in the order of filepath.WalkDir, changedFiles is modified in place.
+func collapseIgnoredFolders(paths []string, changedFiles map[string]ChangedFile) {
+	const containsIgnored = 1
+	const containsOther = 2 // Tracked or untracked files
+
+	insideIgnoredFolder := func(path string) bool {
+		for folder := filepath.Dir(path); folder != "."; folder = filepath.Dir(folder) {
+			if changedFiles[folder].Ignored {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Going backwards visits the contents of a folder before the folder itself
+	folderContents := make(map[string]uint8)
+	collapsible := make(map[string]bool)
+	for i := len(paths) - 1; i >= 0; i-- {
+		rel := paths[i]
+		isDir := rel[len(rel)-1] == '/' // We added this '/' manually in getPathsRecursivelyRelativeTo()
+		path := strings.TrimSuffix(rel, "/")
+
+		var contents uint8
+		if changedFiles[path].Ignored {
+			contents = containsIgnored
+		} else if isDir {
+			contents = folderContents[path]
+			if contents == containsIgnored {
+				collapsible[path] = true
+			}
+		} else if !insideIgnoredFolder(path) {
+			contents = containsOther
+		}
+
+		folderContents[filepath.Dir(path)] |= contents
+	}
+
+	if len(collapsible) == 0 {
+		return
+	}
+
+	topmostCollapsible := func(path string) string {
+		topmost := ""
+		for folder := filepath.Dir(path); folder != "."; folder = filepath.Dir(folder) {
+			if collapsible[folder] {
+				topmost = folder
+			}
+		}
+		return topmost
+	}
+
+	for path, changedFile := range changedFiles {
+		if !changedFile.Ignored {
+			continue
+		}
+
+		if folder := topmostCollapsible(path); folder != "" {
+			delete(changedFiles, path)
+			changedFiles[folder] = ChangedFile{Ignored: true}
+		}
+	}
+}
+
 // Use this function to also include directories containing unstaged/untracked files
 // by passing the output of Status() or StatusWithContext() through this function.
 // Does not modify the changedFiles input argument.
@@ -839,14 +1304,38 @@ func Status(path string, numCPUsOptional ...int) (map[string]ChangedFile, error)
 }
 
 // Cancellable with context, takes in the root path of a local git repository and returns the list of changed (unstaged/untracked) files in filepaths relative to path, or an error.
+// The ".git" folder may also be a ".git" file pointing to it, like in linked worktrees and submodules.
+// Returns an *InvalidRepositoryError if the ".git" folder is not a valid Git directory, e.g. an empty folder.
 func StatusWithContext(ctx context.Context, path string, numCPUsOptional ...int) (map[string]ChangedFile, error) {
-	dotGitPath := myJoin(path, ".git")
-	stat, err := os.Stat(dotGitPath)
-	if err != nil || !stat.IsDir() {
-		return nil, errors.New("not a Git repository")
+	repo, err := OpenRepository(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return status(ctx, repo, true, IGNORED_NO, numCPUsFromOptional(numCPUsOptional))
+}
+
+// Cancellable with context, returns the list of changed (unstaged/untracked) files in filepaths relative to repo.WorkTree, or an error.
+// Use this with DiscoverRepository() to run from a subfolder, or to use the GIT_DIR, GIT_WORK_TREE and GIT_INDEX_FILE environment variables.
+func StatusRepository(ctx context.Context, repo *Repository, numCPUsOptional ...int) (map[string]ChangedFile, error) {
+	return StatusRepositoryWithIgnored(ctx, repo, IGNORED_NO, numCPUsOptional...)
+}
+
+// Same as StatusRepository(), but also returns ignored files and folders (ChangedFile.Ignored) as specified by ignoredMode.
+// Ignored folders are returned without a trailing path separator.
+func StatusRepositoryWithIgnored(ctx context.Context, repo *Repository, ignoredMode IgnoredMode, numCPUsOptional ...int) (map[string]ChangedFile, error) {
+	if repo.WorkTree == "" {
+		return nil, errors.New("this operation must be run in a work tree")
 	}
 
-	return StatusRaw(ctx, path, myJoin(dotGitPath, "index"), true, numCPUsOptional...)
+	return status(ctx, repo, true, ignoredMode, numCPUsFromOptional(numCPUsOptional))
+}
+
+func numCPUsFromOptional(numCPUsOptional []int) int {
+	if len(numCPUsOptional) > 0 {
+		return numCPUsOptional[0]
+	}
+	return runtime.NumCPU()
 }
 
 type sliceType struct {
@@ -899,7 +1388,22 @@ func spreadArrayIntoSlicesForGoroutines(arrayLength, numGoroutines int) []sliceT
 	return result
 }
 
-func trackedPathsChanged(ctx context.Context, path string, indexEntries map[string]GitIndexEntry, numCPUs int) (map[string]ChangedFile, error) {
+// Tries both the precomposed (NFC) and decomposed (NFD) forms of relativePath inside path, returning the one that exists
+func lstatOtherNormalizationForm(path, relativePath string) (os.FileInfo, string, error) {
+	var err error
+	for _, form := range []norm.Form{norm.NFC, norm.NFD} {
+		fullPath := path + string(os.PathSeparator) + form.String(relativePath)
+		var stat os.FileInfo
+		stat, err = os.Lstat(fullPath)
+		if err == nil {
+			return stat, fullPath, nil
+		}
+	}
+	return nil, "", err
+}
+
+// Entries outside of sparse are not checked, sparse can be nil
+func trackedPathsChanged(ctx context.Context, path string, indexEntries map[string]GitIndexEntry, sparse *sparseCheckout, settings *statusSettings, numCPUs int) (map[string]ChangedFile, error) {
 	outs := make([]map[string]ChangedFile, numCPUs)
 	for i := range outs {
 		outs[i] = make(map[string]ChangedFile)
@@ -941,11 +1445,34 @@ func trackedPathsChanged(ctx context.Context, path string, indexEntries map[stri
 					// Faster than filepath.Join()
 					fullPath := path + string(os.PathSeparator) + entryPathFromSlash
 
+					// Skip-worktree entries are not expected to be on disk, this includes the directory entries of a sparse index
+					if entry.SkipWorktree || !sparse.includes(entryPath) {
+						continue
+					}
+
+					// Assume-unchanged entries are never checked, not even if they are deleted
+					if entry.AssumeValid {
+						continue
+					}
+
+					// Unmerged paths are reported regardless of the file on disk, like in Git
+					if entry.Conflict != 0 {
+						outs[threadIdx][entryPathFromSlash] = ChangedFile{Conflict: entry.Conflict, Untracked: false}
+						continue
+					}
+
 					stat, statErr := os.Lstat(fullPath)
+					if statErr != nil && settings.precomposeUnicode {
+						// The file may be on disk in the other Unicode normalization form
+						stat, fullPath, statErr = lstatOtherNormalizationForm(path, entryPathFromSlash)
+					}
 					if statErr != nil {
 						outs[threadIdx][entryPathFromSlash] = ChangedFile{WhatChanged: DELETED, Untracked: false}
+					} else if entry.IntentToAdd {
+						// The entry has the empty blob hash, so there is nothing to compare against
+						outs[threadIdx][entryPathFromSlash] = ChangedFile{WhatChanged: INTENT_TO_ADD, Untracked: false}
 					} else {
-						whatChanged := fileChanged(entry, fullPath, stat)
+						whatChanged := fileChanged(entry, fullPath, stat, settings)
 						if whatChanged != 0 {
 							outs[threadIdx][entryPathFromSlash] = ChangedFile{WhatChanged: whatChanged, Untracked: false}
 						}
@@ -968,14 +1495,41 @@ func trackedPathsChanged(ctx context.Context, path string, indexEntries map[stri
 }
 
 // Cancellable with context, does not check if path is a valid git repository.
+// Only the index file is read from the Git directory, so Git's default config is used, the repository is expected to use SHA-1,
+// a split index is not supported and only the .gitignore files are respected.
+// Use StatusRawWithGitDir() to also read the config and the other files in the Git directory.
 func StatusRaw(ctx context.Context, path string, gitIndexPath string, respectGitIgnore bool, numCPUsOptional ...int) (map[string]ChangedFile, error) {
-	var numCPUs int
-	if len(numCPUsOptional) > 0 {
-		numCPUs = numCPUsOptional[0]
-	} else {
-		numCPUs = runtime.NumCPU()
+	repo := &Repository{
+		WorkTree:  path,
+		IndexFile: gitIndexPath,
+	}
+
+	return status(ctx, repo, respectGitIgnore, IGNORED_NO, numCPUsFromOptional(numCPUsOptional))
+}
+
+// Same as StatusRaw(), but the config, the excludes files, the hash algorithm and the shared index of a split index are read from gitDirPath (usually a ".git" folder).
+// gitIndexPath doesn't have to be inside gitDirPath, like with GIT_INDEX_FILE.
+func StatusRawWithGitDir(ctx context.Context, path string, gitIndexPath string, gitDirPath string, respectGitIgnore bool, numCPUsOptional ...int) (map[string]ChangedFile, error) {
+	commonDir, err := readCommonDir(gitDirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	repo := &Repository{
+		WorkTree:  path,
+		GitDir:    gitDirPath,
+		CommonDir: commonDir,
+		IndexFile: gitIndexPath,
 	}
 
+	return status(ctx, repo, respectGitIgnore, IGNORED_NO, numCPUsFromOptional(numCPUsOptional))
+}
+
+// Without repo.GitDir, only the index file is read, see StatusRaw()
+func status(ctx context.Context, repo *Repository, respectGitIgnore bool, ignoredMode IgnoredMode, numCPUs int) (map[string]ChangedFile, error) {
+	path := repo.WorkTree
+	gitIndexPath := repo.IndexFile
+
 	stat, err := os.Stat(path)
 	if err != nil || !stat.IsDir() {
 		return nil, errors.New("path does not exist: " + path)
@@ -995,6 +1549,27 @@ func StatusRaw(ctx context.Context, path string, gitIndexPath string, respectGit
 		}
 		walkDirWaitGroup.Done()
 	}()
+	// Don't return while the walk is still running, not even on errors
+	defer walkDirWaitGroup.Wait()
+
+	config := &Config{}
+	var excludesPaths []string
+	if repo.GitDir != "" {
+		config, err = ReadConfig(repo)
+		if err != nil {
+			return nil, err
+		}
+
+		excludesPaths, err = excludesFilePaths(repo, config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	settings, err := readStatusSettings(config)
+	if err != nil {
+		return nil, err
+	}
 
 	// If .git/index file is missing, all files are unstaged/untracked
 	_, err = os.Stat(gitIndexPath)
@@ -1003,11 +1578,24 @@ func StatusRaw(ctx context.Context, path string, gitIndexPath string, respectGit
 		if walkDirError != nil {
 			return nil, walkDirError
 		}
-		return untrackedPathsNotIgnored(ctx, paths, gitIgnorePaths, path, make(map[string]GitIndexEntry), respectGitIgnore, numCPUs)
+		return untrackedPathsNotIgnored(ctx, paths, gitIgnorePaths, excludesPaths, path, make(map[string]GitIndexEntry), respectGitIgnore, settings, ignoredMode, numCPUs)
+	}
+
+	hashAlgorithm := SHA1
+	if repo.GitDir != "" {
+		hashAlgorithm, err = ReadHashAlgorithm(repo.CommonDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sparse, err := readSparseCheckout(repo.GitDir, config)
+	if err != nil {
+		return nil, err
 	}
 
 	start := time.Now()
-	indexEntries, err := ParseGitIndex(ctx, gitIndexPath)
+	indexEntries, err := parseGitIndexMergingSharedIndex(ctx, gitIndexPath, repo.GitDir, hashAlgorithm)
 	if gogitstatus_debug_profiling {
 		fmt.Println("ParseGitIndex:", time.Since(start))
 	}
@@ -1028,11 +1616,13 @@ func StatusRaw(ctx context.Context, path string, gitIndexPath string, respectGit
 			return
 		}
 
-		untrackedPaths, pathsErr = untrackedPathsNotIgnored(ctx, paths, gitIgnorePaths, path, indexEntries, respectGitIgnore, numCPUs)
+		untrackedPaths, pathsErr = untrackedPathsNotIgnored(ctx, paths, gitIgnorePaths, excludesPaths, path, indexEntries, respectGitIgnore, settings, ignoredMode, numCPUs)
 	}()
+	// Don't return while untrackedPathsNotIgnored() is still running, not even on errors
+	defer wg.Wait()
 
 	start = time.Now()
-	out, err := trackedPathsChanged(ctx, path, indexEntries, numCPUs)
+	out, err := trackedPathsChanged(ctx, path, indexEntries, sparse, settings, numCPUs)
 	if gogitstatus_debug_profiling {
 		fmt.Println("Tracked:", time.Since(start))
 	}
@@ -19,9 +19,7 @@ import (
 	"strings"
 	"testing"
 	"time"
-
 	//"github.com/sabhiram/go-gitignore"
-	ignore "github.com/botondmester/goignore"
 )
 
 func printRed(text string) {
@@ -146,6 +144,11 @@ func getNumberFromFolderName(folderName string) (int, error) {
 }
 
 func TestStatus(t *testing.T) {
+	// Don't let the system and global config of this computer change the results
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "true")
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "gitconfig"))
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
 	testsPath := "./tests-status"
 	tests, err := os.ReadDir(testsPath)
 	if err != nil {
@@ -162,6 +165,10 @@ func TestStatus(t *testing.T) {
 			return "Tracked  "
 		}
 		for k, v := range entries {
+			if v.Conflict != 0 {
+				fmt.Println("    Unmerged ", ConflictTypeToString(v.Conflict)+" "+k)
+				continue
+			}
 			fmt.Println("    "+untracked2Str(v.Untracked), WhatChangedToString(v.WhatChanged)+" "+k)
 		}
 	}
@@ -232,13 +239,22 @@ func TestStatus(t *testing.T) {
 			}
 
 			split := strings.Split(line, " ")
+			if split[0] == "Unmerged" {
+				if len(split) < 3 || StringToConflictType(split[1]) == 0 {
+					t.Fatal("Invalid test, expected a conflict type and a path after \"Unmerged\" in line:", line)
+				}
+
+				expectedChangedFiles[filepath.FromSlash(split[2])] = ChangedFile{Conflict: StringToConflictType(split[1])}
+				continue
+			}
+
 			var untracked bool
 			if split[0] == "Untracked" {
 				untracked = true
 			} else if split[0] == "Tracked" {
 				untracked = false
 			} else {
-				t.Fatal("Invalid test, first word should be one of: \"Untracked\", \"Tracked\", case matching")
+				t.Fatal("Invalid test, first word should be one of: \"Untracked\", \"Tracked\", \"Unmerged\", case matching")
 			}
 			var whatChangedText string
 			var pathText string
@@ -331,7 +347,7 @@ func TestStatus(t *testing.T) {
 
 		// Special yellow warnings when a test failed only in single or multi-threaded, but not in the other
 		if multiThreadFailed && (!singleThreadFailed) {
-			fmt.Println("\x1b[33m^ Failed only when running multi-threaded (" + strconv.Itoa(numCPUs) + " CPUs) (Bug in goignore which would otherwise be masked by our skipping of directories? Or just a threading bug?)\x1b[0m")
+			fmt.Println("\x1b[33m^ Failed only when running multi-threaded (" + strconv.Itoa(numCPUs) + " CPUs) (Bug in our ignore matching which would otherwise be masked by our skipping of directories? Or just a threading bug?)\x1b[0m")
 		} else if singleThreadFailed && (!multiThreadFailed) {
 			fmt.Println("\x1b[33m^ Failed only when running single-threaded !")
 			fmt.Println("  May be a bug in our skipdir logic? Try setting gogitstatus_debug_disable_skipdir = true.")
@@ -355,227 +371,1637 @@ func TestStatus(t *testing.T) {
 	}
 }
 
-func TestParseGitIndex(t *testing.T) {
-	testsPath := "./tests-index-parser"
-	tests, err := os.ReadDir(testsPath)
+// A split index outside of the Git directory with GIT_INDEX_FILE, its shared index is still in the Git directory
+func TestStatusSplitIndexFile(t *testing.T) {
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "true")
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "gitconfig"))
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("GIT_CEILING_DIRECTORIES", "")
+	t.Setenv("GIT_DIR", "")
+	t.Setenv("GIT_WORK_TREE", "")
+
+	dir := t.TempDir()
+	err := extractZipArchive(filepath.Join("test-data", "split_index_file.zip"), dir)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	fmt.Println()
-	printGray("TestParseGitIndex:\n")
+	t.Setenv("GIT_INDEX_FILE", filepath.Join(dir, "idx", "index"))
+	repo, err := DiscoverRepository(filepath.Join(dir, "repo"), DiscoverOptions{UseEnvironment: true})
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	printEntries := func(entries map[string]GitIndexEntry) {
-		for path, e := range entries {
-			fmt.Println("    "+strconv.FormatUint(uint64(e.Mode), 8), hex.EncodeToString(e.Hash[:]), path)
-		}
+	changedFiles, err := StatusRepository(context.Background(), repo)
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	gitIndexEntriesMatch := func(a, b map[string]GitIndexEntry) bool {
-		if len(a) != len(b) {
-			return false
-		}
+	expected := map[string]ChangedFile{
+		"a.txt":   {WhatChanged: DATA_CHANGED},
+		"new.txt": {Untracked: true},
+	}
+	if !reflect.DeepEqual(changedFiles, expected) {
+		t.Fatal("Expected", expected, "but got:", changedFiles)
+	}
 
-		for k, v := range a {
-			if !reflect.DeepEqual(b[k].Hash, v.Hash) {
-				return false
-			}
+	changedFiles, err = StatusRawWithGitDir(context.Background(), filepath.Join(dir, "repo"), filepath.Join(dir, "idx", "index"), filepath.Join(dir, "repo", ".git"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-			// We don't test the mode
-			/*if b[k].Mode != v.Mode {
-				return false
-			}*/
-		}
+	if !reflect.DeepEqual(changedFiles, expected) {
+		t.Fatal("Expected", expected, "from StatusRawWithGitDir(), but got:", changedFiles)
+	}
 
-		return true
+	// info/exclude is read from the Git directory too
+	if err := os.WriteFile(filepath.Join(dir, "repo", ".git", "info", "exclude"), []byte("new.txt\n"), 0644); err != nil {
+		t.Fatal(err)
 	}
 
-	testFailed := false
+	changedFiles, err = StatusRawWithGitDir(context.Background(), filepath.Join(dir, "repo"), filepath.Join(dir, "idx", "index"), filepath.Join(dir, "repo", ".git"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	for _, version := range tests {
-		versionTests, err := os.ReadDir(filepath.Join(testsPath, version.Name()))
+	delete(expected, "new.txt")
+	if !reflect.DeepEqual(changedFiles, expected) {
+		t.Fatal("Expected", expected, "from StatusRawWithGitDir() with info/exclude, but got:", changedFiles)
+	}
+}
+
+// Linked worktrees and submodules have a ".git" file pointing to the real Git directory
+func TestStatusGitFile(t *testing.T) {
+	// Don't let the system and global config of this computer change the results
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "true")
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "gitconfig"))
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("GIT_CEILING_DIRECTORIES", "")
+	t.Setenv("GIT_DIR", "")
+	t.Setenv("GIT_WORK_TREE", "")
+	t.Setenv("GIT_INDEX_FILE", "")
+
+	dir := t.TempDir()
+	err := extractZipArchive(filepath.Join("test-data", "linked_worktree_and_submodule.zip"), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type TestCase struct {
+		path     string
+		expected map[string]ChangedFile
+	}
+
+	tests := []TestCase{
+		{"main", map[string]ChangedFile{
+			"main_new.txt": {Untracked: true},
+		}},
+		{"wt", map[string]ChangedFile{
+			"m.txt":      {WhatChanged: DATA_CHANGED},
+			"wt_new.txt": {Untracked: true},
+		}},
+		{filepath.Join("main", "sub"), map[string]ChangedFile{
+			"s.txt":       {WhatChanged: DATA_CHANGED},
+			"sub_new.txt": {Untracked: true},
+		}},
+	}
+
+	for _, test := range tests {
+		changedFiles, err := Status(filepath.Join(dir, test.path))
 		if err != nil {
-			fmt.Println(err)
-			testFailed = true
-			continue
+			t.Fatal("Expected no error for", test.path, "but got:", err)
 		}
 
-		// Sort our tests by the numbers in the folder names
-		slices.SortFunc(versionTests, func(a, b os.DirEntry) int {
-			num1, err := getNumberFromFolderName(a.Name())
-			if err != nil {
-				t.Fatal("Missing number prefix like \"25_\" in folder named:", a.Name())
-			}
-			num2, err := getNumberFromFolderName(b.Name())
-			if err != nil {
-				t.Fatal("Missing number prefix like \"25_\" in folder named:", b.Name())
-			}
+		if !reflect.DeepEqual(changedFiles, test.expected) {
+			t.Fatal("Expected", test.expected, "for", test.path, "but got:", changedFiles)
+		}
+	}
 
-			return num1 - num2
-		})
-		for _, versionTest := range versionTests {
-			indexPath := filepath.Join(testsPath, version.Name(), versionTest.Name(), "index")
-			expectedPath := filepath.Join(testsPath, version.Name(), versionTest.Name(), "expected.txt")
-			fmt.Print("Test " + filepath.Dir(expectedPath) + ": ")
+	repo, err := OpenRepository(filepath.Join(dir, "wt"))
+	if err != nil {
+		t.Fatal(err)
+	}
 
-			file, err := os.Open(expectedPath)
-			if err != nil {
-				printRed("Failed\n")
-				fmt.Println(err)
-				testFailed = true
-				continue
-			}
+	if repo.GitDir != filepath.Join(dir, "main", ".git", "worktrees", "wt") || repo.CommonDir != filepath.Join(dir, "main", ".git") {
+		t.Fatal("Expected the worktree Git directory and the common directory of main, but got:", repo)
+	}
 
-			expectedEntries := make(map[string]GitIndexEntry)
-			var expectedError error = nil
+	if err := os.WriteFile(filepath.Join(dir, "wt", ".git"), []byte("not a gitdir pointer\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
 
-			scanner := bufio.NewScanner(file)
-			for scanner.Scan() {
-				line := scanner.Text()
-				if strings.HasPrefix(line, "Error text:") {
-					expectedEntries = make(map[string]GitIndexEntry)
-					expectedError = errors.New(line[len("Error text:"):])
-					break
-				}
+	if _, err := Status(filepath.Join(dir, "wt")); err == nil {
+		t.Fatal("Expected an error for an invalid .git file, but got nil")
+	}
+}
 
-				sha1HashHex := line[:40]
-				pathName := line[41:]
+func TestGlobalExcludesFile(t *testing.T) {
+	dir := t.TempDir()
+	err := extractZipArchive(filepath.Join("tests-status", "52_info_exclude", "files.zip"), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-				sha1HashBytes, err := hex.DecodeString(sha1HashHex)
-				if err != nil {
-					printRed("Failed\n")
-					fmt.Println(err)
-					testFailed = true
-					continue
-				}
+	home := t.TempDir()
+	xdgConfigHome := filepath.Join(home, "xdg")
+	globalConfig := filepath.Join(home, "gitconfig")
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "true")
+	t.Setenv("GIT_CONFIG_GLOBAL", globalConfig)
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	t.Setenv("XDG_CONFIG_HOME", xdgConfigHome)
 
-				expectedEntries[pathName] = GitIndexEntry{Hash: [20]byte(sha1HashBytes)}
-			}
-			file.Close()
+	write := func(path, content string) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
 
-			ctx := context.WithoutCancel(context.Background())
-			entries, err := ParseGitIndex(ctx, indexPath)
-			if expectedError == nil && err != nil {
-				printRed("Failed\n")
-				fmt.Println("expected no error, but got: " + err.Error())
-				testFailed = true
-				continue
-			}
+	// The negation doesn't apply, since $GIT_DIR/info/exclude has a higher precedence
+	write(filepath.Join(xdgConfigHome, "git", "ignore"), "other.txt\n!debug.log\n")
+	write(filepath.Join(home, "custom-ignore"), ".gitignore\n")
 
-			if err != nil && expectedError != nil {
-				if err.Error() != expectedError.Error() {
-					printRed("Failed\n")
-					fmt.Println("expected error text \"" + expectedError.Error() + "\", but got: \"" + err.Error() + "\"")
-					testFailed = true
-					continue
-				}
-			}
+	type TestCase struct {
+		config   string
+		expected map[string]ChangedFile
+	}
 
-			if !gitIndexEntriesMatch(entries, expectedEntries) {
-				printRed("Failed\n")
+	tests := []TestCase{
+		{"", map[string]ChangedFile{
+			".gitignore": {Untracked: true},
+			"keep.log":   {Untracked: true},
+		}},
+		{"[core]\n\texcludesFile = ~/custom-ignore\n", map[string]ChangedFile{
+			"keep.log":  {Untracked: true},
+			"other.txt": {Untracked: true},
+		}},
+		{"[core]\n\texcludesFile =\n", map[string]ChangedFile{
+			".gitignore": {Untracked: true},
+			"keep.log":   {Untracked: true},
+			"other.txt":  {Untracked: true},
+		}},
+	}
 
-				fmt.Println("Expected entries:")
-				printEntries(expectedEntries)
-				fmt.Println("But got:")
-				printEntries(entries)
-				testFailed = true
-				continue
-			}
+	for _, test := range tests {
+		write(globalConfig, test.config)
 
-			printGreen("Success\n")
+		changedFiles, err := Status(dir)
+		if err != nil {
+			t.Fatal("Expected no error for config", strconv.Quote(test.config), "but got:", err)
 		}
-	}
 
-	if testFailed {
-		t.Fatal("See above ^")
+		if !reflect.DeepEqual(changedFiles, test.expected) {
+			t.Fatal("Expected", test.expected, "for config", strconv.Quote(test.config), "but got:", changedFiles)
+		}
 	}
-
-	fmt.Println()
 }
 
-func TestIncludingDirectories(t *testing.T) {
-	c := func(path string) string {
-		return filepath.FromSlash(path)
+func TestStatusIgnored(t *testing.T) {
+	dir := t.TempDir()
+	err := extractZipArchive(filepath.Join("test-data", "ignored_files.zip"), dir)
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	changedFiles := map[string]ChangedFile{
-		c("main.go"):        {},
-		c("screenshots/hi"): {},
-	}
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "true")
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "gitconfig"))
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
 
-	expected := map[string]ChangedFile{
-		c("main.go"):        {},
-		c("screenshots/hi"): {},
-		c("screenshots"):    {},
+	repo, err := OpenRepository(dir)
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	got := IncludingDirectories(changedFiles)
+	// Same as `git status --ignored=<mode>`, vendor/ has a tracked file so it is never collapsed
+	untracked := map[string]ChangedFile{
+		".gitignore":      {Untracked: true},
+		"src/keep.c":      {Untracked: true},
+		"src/c.log":       {Ignored: true},
+		"top.log":         {Ignored: true},
+		"vendor/junk.txt": {Ignored: true},
+	}
+
+	tests := map[IgnoredMode]map[string]ChangedFile{
+		IGNORED_NO: {
+			".gitignore": {Untracked: true},
+			"src/keep.c": {Untracked: true},
+		},
+		IGNORED_TRADITIONAL: {
+			"build": {Ignored: true},
+			"deep":  {Ignored: true},
+			"logs":  {Ignored: true},
+		},
+		IGNORED_MATCHING: {
+			"build":        {Ignored: true},
+			"deep/a/b.log": {Ignored: true},
+			"logs/x.log":   {Ignored: true},
+			"logs/y.log":   {Ignored: true},
+		},
+	}
+
+	for ignoredMode, expected := range tests {
+		if ignoredMode != IGNORED_NO {
+			for path, changedFile := range untracked {
+				expected[path] = changedFile
+			}
+		}
 
-	if !reflect.DeepEqual(got, expected) {
-		t.Fatal("TestIncludingDirectories (1) got an unexpected value")
-	}
+		expectedFromSlash := make(map[string]ChangedFile)
+		for path, changedFile := range expected {
+			expectedFromSlash[filepath.FromSlash(path)] = changedFile
+		}
 
-	changedFiles = map[string]ChangedFile{
-		c("main.go"):                        {},
-		c("screenshots/hi"):                 {},
-		c("folder/anotherfolder/hello.txt"): {},
-		c("folder/anotherfolder/hi"):        {},
-		c("folder/file.txt"):                {},
-		c("folder/anotherfile.txt"):         {},
-	}
+		for _, numCPUs := range []int{1, max(2, runtime.NumCPU())} {
+			changedFiles, err := StatusRepositoryWithIgnored(context.Background(), repo, ignoredMode, numCPUs)
+			if err != nil {
+				t.Fatal("Expected no error for ignored mode", ignoredMode, "but got:", err)
+			}
 
-	expected = map[string]ChangedFile{
-		c("main.go"):                        {},
-		c("screenshots/hi"):                 {},
-		c("folder/anotherfolder/hello.txt"): {},
-		c("folder/anotherfolder/hi"):        {},
-		c("folder/file.txt"):                {},
-		c("folder/anotherfile.txt"):         {},
+			if !reflect.DeepEqual(changedFiles, expectedFromSlash) {
+				t.Fatal("Expected", expectedFromSlash, "for ignored mode", ignoredMode, "with", numCPUs, "CPUs, but got:", changedFiles)
+			}
+		}
+	}
+}
 
-		c("screenshots"):          {},
-		c("folder/anotherfolder"): {},
-		c("folder"):               {},
+func TestCheckIgnore(t *testing.T) {
+	dir := t.TempDir()
+	write := func(path, content string) {
+		path = filepath.Join(dir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
 	}
 
-	got = IncludingDirectories(changedFiles)
+	write("repo/.git/HEAD", "ref: refs/heads/main\n")
+	write("repo/.git/objects/.keep", "")
+	write("repo/.git/refs/.keep", "")
+	write("repo/.git/info/exclude", "scratch/\nkeep.log\n")
+	write("repo/.gitignore", "*.log\n!keep.log\nbuild/\n# comment\n\\#hash\ntrailing  \nescaped\\ \n")
+	write("repo/sub/.gitignore", "!debug.log\n/local.txt\n")
+	write("repo/build/.gitignore", "!x.o\n")
+	write("xdg/git/ignore", "*.swp\n")
 
-	if !reflect.DeepEqual(got, expected) {
-		t.Fatal("TestIncludingDirectories (2) got an unexpected value")
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "true")
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(dir, "gitconfig"))
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "xdg"))
+
+	repo, err := OpenRepository(filepath.Join(dir, "repo"))
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	empty := map[string]ChangedFile{}
-	got = IncludingDirectories(empty)
-	if !reflect.DeepEqual(got, empty) {
-		t.Fatal("TestIncludingDirectories expected no values, but got some")
+	type TestCase struct {
+		path     string
+		expected *IgnoreMatch
 	}
 
-	changedFiles = map[string]ChangedFile{
-		c("many/folders/for/sure/oh/yeah.txt"): {},
+	// Same as `git check-ignore -v --no-index`
+	tests := []TestCase{
+		{"a.log", &IgnoreMatch{true, ".gitignore", 1, "*.log"}},
+		{"keep.log", &IgnoreMatch{false, ".gitignore", 2, "!keep.log"}},
+		{"sub/debug.log", &IgnoreMatch{false, filepath.FromSlash("sub/.gitignore"), 1, "!debug.log"}},
+		{"sub/local.txt", &IgnoreMatch{true, filepath.FromSlash("sub/.gitignore"), 2, "/local.txt"}},
+		{"sub/deep/a.log", &IgnoreMatch{true, ".gitignore", 1, "*.log"}},
+		{"local.txt", nil},
+		{"build", &IgnoreMatch{true, ".gitignore", 3, "build/"}},
+		{"build/x.o", &IgnoreMatch{true, ".gitignore", 3, "build/"}},
+		{"out", nil},
+		{"out/", nil},
+		{"#hash", &IgnoreMatch{true, ".gitignore", 5, "\\#hash"}},
+		{"trailing", &IgnoreMatch{true, ".gitignore", 6, "trailing"}},
+		{"escaped ", &IgnoreMatch{true, ".gitignore", 7, "escaped\\ "}},
+		{"escaped", nil},
+		{"scratch/new.txt", &IgnoreMatch{true, filepath.FromSlash(".git/info/exclude"), 1, "scratch/"}},
+		{"x.swp", &IgnoreMatch{true, filepath.Join(dir, "xdg", "git", "ignore"), 1, "*.swp"}},
+		{"does/not/exist.log", &IgnoreMatch{true, ".gitignore", 1, "*.log"}},
+		{filepath.Join(dir, "repo", "a.log"), &IgnoreMatch{true, ".gitignore", 1, "*.log"}},
+		{"src/main.c", nil},
 	}
-	expected = map[string]ChangedFile{
-		c("many"):                              {},
-		c("many/folders"):                      {},
-		c("many/folders/for"):                  {},
-		c("many/folders/for/sure"):             {},
-		c("many/folders/for/sure/oh"):          {},
-		c("many/folders/for/sure/oh/yeah.txt"): {},
+
+	for _, test := range tests {
+		match, err := CheckIgnore(repo, test.path)
+		if err != nil {
+			t.Fatal("Expected no error for", test.path, "but got:", err)
+		}
+
+		if !reflect.DeepEqual(match, test.expected) {
+			t.Fatal("Expected", test.expected, "for", test.path, "but got:", match)
+		}
 	}
-	got = IncludingDirectories(changedFiles)
-	if !reflect.DeepEqual(got, expected) {
-		t.Fatal("TestIncludingDirectories did not recursively add directories to the map")
+
+	if _, err := CheckIgnore(repo, "../outside.txt"); err == nil {
+		t.Fatal("Expected an error for a path outside of the work tree")
 	}
 
-	changedFiles = map[string]ChangedFile{
-		c("main.go"):        {},
-		c("screenshots/hi"): {},
+	// Like in Status(), an excludes file that can't be read is skipped
+	globalExcludesPath := filepath.Join(dir, "xdg", "git", "ignore")
+	if err := os.Remove(globalExcludesPath); err != nil {
+		t.Fatal(err)
 	}
-	expected = map[string]ChangedFile{
-		c("main.go"):        {},
-		c("screenshots/hi"): {},
+	if err := os.Mkdir(globalExcludesPath, 0755); err != nil {
+		t.Fatal(err)
 	}
-	IncludingDirectories(changedFiles)
-	if !reflect.DeepEqual(changedFiles, expected) {
-		t.Fatal("TestIncludingDirectories overwrote the input argument!")
+
+	match, err := CheckIgnore(repo, "x.swp")
+	if err != nil || match != nil {
+		t.Fatal("Expected no match and no error with an unreadable excludes file, but got:", match, err)
+	}
+}
+
+func TestDiscoverRepository(t *testing.T) {
+	t.Setenv("GIT_CEILING_DIRECTORIES", "")
+
+	dir := t.TempDir()
+	for _, path := range []string{"repo/.git/objects", "repo/.git/refs", "repo/a/b", "bare.git/objects", "bare.git/refs", "bare.git/c"} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.FromSlash(path)), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, path := range []string{"repo/.git/HEAD", "bare.git/HEAD"} {
+		if err := os.WriteFile(filepath.Join(dir, filepath.FromSlash(path)), []byte("ref: refs/heads/main\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	repoPath := filepath.Join(dir, "repo")
+	bPath := filepath.Join(repoPath, "a", "b")
+
+	type TestCase struct {
+		startPath        string
+		ceilingDirectory string
+		envCeiling       string
+		expectedWorkTree string // Empty means no repository should be found
+		expectedGitDir   string
+	}
+
+	tests := []TestCase{
+		{bPath, "", "", repoPath, filepath.Join(repoPath, ".git")},
+		{repoPath, "", "", repoPath, filepath.Join(repoPath, ".git")},
+		{bPath, repoPath, "", "", ""},                                             // Does not go up into the ceiling directory
+		{bPath, "", repoPath, "", ""},                                             // Same, but from the environment variable
+		{bPath, "", "relative" + string(os.PathListSeparator) + repoPath, "", ""}, // Relative paths are ignored
+		{repoPath, repoPath, "", repoPath, filepath.Join(repoPath, ".git")},       // The start folder is always checked
+		{bPath, filepath.Join(repoPath, "a", "b", "c"), "", repoPath, filepath.Join(repoPath, ".git")},
+		{filepath.Join(dir, "bare.git", "c"), "", "", "", filepath.Join(dir, "bare.git")},
+	}
+
+	for i, test := range tests {
+		t.Setenv("GIT_CEILING_DIRECTORIES", test.envCeiling)
+
+		var ceilingDirectories []string
+		if test.ceilingDirectory != "" {
+			ceilingDirectories = []string{test.ceilingDirectory}
+		}
+
+		repo, err := DiscoverRepository(test.startPath, DiscoverOptions{CeilingDirectories: ceilingDirectories})
+		if test.expectedGitDir == "" {
+			if err == nil {
+				t.Fatal("Expected no repository in test index", i, "but got:", repo)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatal("Expected no error in test index", i, "but got:", err)
+		}
+
+		if repo.WorkTree != test.expectedWorkTree || repo.GitDir != test.expectedGitDir || repo.CommonDir != test.expectedGitDir {
+			t.Fatal("Expected work tree", test.expectedWorkTree, "and Git directory", test.expectedGitDir, "in test index", i, "but got:", repo)
+		}
+	}
+
+	changedFiles := map[string]ChangedFile{
+		filepath.Join("a", "b", "file.txt"): {Untracked: true},
+		"root.txt":                          {WhatChanged: DELETED},
+	}
+	expected := map[string]ChangedFile{
+		"file.txt":                            {Untracked: true},
+		filepath.Join("..", "..", "root.txt"): {WhatChanged: DELETED},
+	}
+
+	got, err := RelativeToDirectory(changedFiles, repoPath, bPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatal("Expected", expected, "but got:", got)
+	}
+}
+
+func TestDiscoverRepositoryEnvironment(t *testing.T) {
+	// Don't let the system and global config of this computer change the results
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "true")
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "gitconfig"))
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("GIT_CEILING_DIRECTORIES", "")
+
+	// Resolved, so the current folder matches it after changing into it
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"home/sub", "dotfiles.git/objects", "dotfiles.git/refs", "repo/.git/objects", "repo/.git/refs", "repo/sub", "configured.git/objects", "configured.git/refs", "wt"} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.FromSlash(path)), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		"dotfiles.git/HEAD":     "ref: refs/heads/main\n",
+		"dotfiles.git/config":   "[core]\n\tbare = true\n",
+		"repo/.git/HEAD":        "ref: refs/heads/main\n",
+		"configured.git/HEAD":   "ref: refs/heads/main\n",
+		"configured.git/config": "[core]\n\tworktree = ../wt\n",
+		"home/file.txt":         "",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, filepath.FromSlash(path)), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type TestCase struct {
+		startPath         string
+		gitDir            string
+		workTree          string
+		indexFile         string
+		expectError       bool
+		expectedWorkTree  string
+		expectedGitDir    string
+		expectedIndexFile string
+	}
+
+	home := filepath.Join(dir, "home")
+	dotfiles := filepath.Join(dir, "dotfiles.git")
+	repo := filepath.Join(dir, "repo")
+
+	// Like in Git, relative paths in GIT_DIR and GIT_WORK_TREE are relative to the current folder
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(home); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(wd)
+	})
+
+	tests := []TestCase{
+		// A bare repository with a separate work tree, like a dotfile manager
+		{home, "../dotfiles.git", ".", "", false, home, dotfiles, filepath.Join(dotfiles, "index")},
+		{filepath.Join(repo, "sub"), "../dotfiles.git", ".", "", false, home, dotfiles, filepath.Join(dotfiles, "index")}, // Not relative to startPath
+		{filepath.Join(home, "sub"), dotfiles, home, "", false, home, dotfiles, filepath.Join(dotfiles, "index")},
+		// core.bare without GIT_WORK_TREE means no work tree
+		{home, dotfiles, "", "", false, "", dotfiles, filepath.Join(dotfiles, "index")},
+		// The current folder is the work tree
+		{filepath.Join(repo, "sub"), filepath.Join(repo, ".git"), "", "", false, filepath.Join(repo, "sub"), filepath.Join(repo, ".git"), filepath.Join(repo, ".git", "index")},
+		// core.worktree is relative to the Git directory
+		{home, filepath.Join(dir, "configured.git"), "", "", false, filepath.Join(dir, "wt"), filepath.Join(dir, "configured.git"), filepath.Join(dir, "configured.git", "index")},
+		// Discovered, with a relative GIT_INDEX_FILE relative to the work tree
+		{filepath.Join(repo, "sub"), "", "", "other-index", false, repo, filepath.Join(repo, ".git"), filepath.Join(repo, "other-index")},
+		{filepath.Join(repo, "sub"), "", home, "/absolute/index", false, home, filepath.Join(repo, ".git"), filepath.Clean("/absolute/index")},
+		{home, "nonexistent", "", "", true, "", "", ""},
+		{home, "sub", "", "", true, "", "", ""},
+	}
+
+	for i, test := range tests {
+		t.Setenv("GIT_DIR", test.gitDir)
+		t.Setenv("GIT_WORK_TREE", test.workTree)
+		t.Setenv("GIT_INDEX_FILE", test.indexFile)
+
+		repo, err := DiscoverRepository(test.startPath, DiscoverOptions{UseEnvironment: true})
+		if test.expectError {
+			if err == nil {
+				t.Fatal("Expected an error in test index", i, "but got:", repo)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatal("Expected no error in test index", i, "but got:", err)
+		}
+
+		expected := Repository{WorkTree: test.expectedWorkTree, GitDir: test.expectedGitDir, CommonDir: test.expectedGitDir, IndexFile: test.expectedIndexFile}
+		if *repo != expected {
+			t.Fatal("Expected", expected, "in test index", i, "but got:", *repo)
+		}
+	}
+
+	// Without UseEnvironment, the environment variables are not used
+	t.Setenv("GIT_DIR", dotfiles)
+	if _, err := DiscoverRepository(home, DiscoverOptions{}); err == nil {
+		t.Fatal("Expected an error without UseEnvironment")
+	}
+
+	t.Setenv("GIT_WORK_TREE", home)
+	t.Setenv("GIT_INDEX_FILE", "")
+	dotfilesRepo, err := DiscoverRepository(home, DiscoverOptions{UseEnvironment: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changedFiles, err := StatusRepository(context.Background(), dotfilesRepo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]ChangedFile{"file.txt": {Untracked: true}}
+	if !reflect.DeepEqual(changedFiles, expected) {
+		t.Fatal("Expected", expected, "but got:", changedFiles)
+	}
+
+	dotfilesRepo.WorkTree = ""
+	if _, err := StatusRepository(context.Background(), dotfilesRepo); err == nil {
+		t.Fatal("Expected an error for a repository without a work tree")
+	}
+}
+
+func TestValidateGitDirectory(t *testing.T) {
+	type TestCase struct {
+		head          string // Not created if empty
+		config        string // Not created if empty
+		missingFolder string
+		expectedValid bool
+	}
+
+	const sha1Hex = "0123456789abcdef0123456789abcdef01234567"
+	const sha256Hex = sha1Hex + "0123456789abcdef01234567"
+
+	tests := []TestCase{
+		{"ref: refs/heads/main\n", "", "", true},
+		{"ref:refs/heads/main", "", "", true},
+		{"ref: \trefs/heads/main\n", "", "", true},
+		{sha1Hex + "\n", "", "", true},
+		{sha256Hex + "\n", "", "", true},
+		{"", "", "", false},
+		{"ref: heads/main\n", "", "", false},
+		{"garbage\n", "", "", false},
+		{sha1Hex[:39] + "\n", "", "", false},
+		{"ref: refs/heads/main\n", "", "objects", false},
+		{"ref: refs/heads/main\n", "", "refs", false},
+		{"ref: refs/heads/main\n", "[core]\n\trepositoryformatversion = 0\n", "", true},
+		{"ref: refs/heads/main\n", "[core]\n\trepositoryformatversion = 1\n[extensions]\n\tobjectFormat = sha256\n", "", true},
+		{"ref: refs/heads/main\n", "[core]\n\trepositoryformatversion = 0\n[extensions]\n\tunknown = true\n", "", true}, // Ignored in version 0
+		{"ref: refs/heads/main\n", "[core]\n\trepositoryformatversion = 1\n[extensions]\n\tunknown = true\n", "", false},
+		{"ref: refs/heads/main\n", "[core]\n\trepositoryformatversion = 2\n", "", false},
+		{"ref: refs/heads/main\n", "[core]\n\trepositoryformatversion = abc\n", "", false},
+	}
+
+	for i, test := range tests {
+		gitDir := filepath.Join(t.TempDir(), ".git")
+		for _, dir := range []string{"objects", "refs"} {
+			if dir == test.missingFolder {
+				continue
+			}
+			if err := os.MkdirAll(filepath.Join(gitDir, dir), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if test.head != "" {
+			if err := os.WriteFile(filepath.Join(gitDir, "HEAD"), []byte(test.head), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if test.config != "" {
+			if err := os.WriteFile(filepath.Join(gitDir, "config"), []byte(test.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		_, err := OpenRepository(filepath.Dir(gitDir))
+		if test.expectedValid {
+			if err != nil {
+				t.Fatal("Expected a valid repository in test index", i, "but got:", err)
+			}
+			continue
+		}
+
+		var invalidErr *InvalidRepositoryError
+		if !errors.As(err, &invalidErr) {
+			t.Fatal("Expected an *InvalidRepositoryError in test index", i, "but got:", err)
+		}
+		if invalidErr.Path != gitDir {
+			t.Fatal("Expected the rejected path", gitDir, "in test index", i, "but got:", invalidErr.Path)
+		}
+	}
+
+	// HEAD may be a symbolic link into "refs/"
+	if runtime.GOOS != "windows" {
+		gitDir := filepath.Join(t.TempDir(), ".git")
+		os.MkdirAll(filepath.Join(gitDir, "objects"), 0755)
+		os.MkdirAll(filepath.Join(gitDir, "refs"), 0755)
+		if err := os.Symlink("refs/heads/main", filepath.Join(gitDir, "HEAD")); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := OpenRepository(filepath.Dir(gitDir)); err != nil {
+			t.Fatal("Expected a symbolic link HEAD to be valid, but got:", err)
+		}
+	}
+
+	// Discovery skips a ".git" folder that is not a Git directory
+	t.Setenv("GIT_CEILING_DIRECTORIES", "")
+	dir := t.TempDir()
+	for _, path := range []string{".git/objects", ".git/refs", "sub/.git"} {
+		os.MkdirAll(filepath.Join(dir, filepath.FromSlash(path)), 0755)
+	}
+	os.WriteFile(filepath.Join(dir, ".git", "HEAD"), []byte("ref: refs/heads/main\n"), 0644)
+
+	repo, err := DiscoverRepository(filepath.Join(dir, "sub"), DiscoverOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo.WorkTree != dir {
+		t.Fatal("Expected work tree", dir, "but got:", repo.WorkTree)
+	}
+}
+
+func TestParseGitIndex(t *testing.T) {
+	testsPath := "./tests-index-parser"
+	tests, err := os.ReadDir(testsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Println()
+	printGray("TestParseGitIndex:\n")
+
+	printEntries := func(entries map[string]GitIndexEntry) {
+		for path, e := range entries {
+			fmt.Println("    "+strconv.FormatUint(uint64(e.Mode), 8), hex.EncodeToString(e.Hash[:]), path)
+		}
+	}
+
+	gitIndexEntriesMatch := func(a, b map[string]GitIndexEntry) bool {
+		if len(a) != len(b) {
+			return false
+		}
+
+		for k, v := range a {
+			if !reflect.DeepEqual(b[k].Hash, v.Hash) {
+				return false
+			}
+
+			// We don't test the mode
+			/*if b[k].Mode != v.Mode {
+				return false
+			}*/
+		}
+
+		return true
+	}
+
+	testFailed := false
+
+	for _, version := range tests {
+		// Folders like "version_2_sha256" contain indexes of SHA-256 repositories
+		hashAlgorithm := SHA1
+		if strings.HasSuffix(version.Name(), "_sha256") {
+			hashAlgorithm = SHA256
+		}
+
+		versionTests, err := os.ReadDir(filepath.Join(testsPath, version.Name()))
+		if err != nil {
+			fmt.Println(err)
+			testFailed = true
+			continue
+		}
+
+		// Sort our tests by the numbers in the folder names
+		slices.SortFunc(versionTests, func(a, b os.DirEntry) int {
+			num1, err := getNumberFromFolderName(a.Name())
+			if err != nil {
+				t.Fatal("Missing number prefix like \"25_\" in folder named:", a.Name())
+			}
+			num2, err := getNumberFromFolderName(b.Name())
+			if err != nil {
+				t.Fatal("Missing number prefix like \"25_\" in folder named:", b.Name())
+			}
+
+			return num1 - num2
+		})
+		for _, versionTest := range versionTests {
+			indexPath := filepath.Join(testsPath, version.Name(), versionTest.Name(), "index")
+			expectedPath := filepath.Join(testsPath, version.Name(), versionTest.Name(), "expected.txt")
+			fmt.Print("Test " + filepath.Dir(expectedPath) + ": ")
+
+			file, err := os.Open(expectedPath)
+			if err != nil {
+				printRed("Failed\n")
+				fmt.Println(err)
+				testFailed = true
+				continue
+			}
+
+			expectedEntries := make(map[string]GitIndexEntry)
+			var expectedError error = nil
+
+			scanner := bufio.NewScanner(file)
+			for scanner.Scan() {
+				line := scanner.Text()
+				if strings.HasPrefix(line, "Error text:") {
+					expectedEntries = make(map[string]GitIndexEntry)
+					expectedError = errors.New(line[len("Error text:"):])
+					break
+				}
+
+				hashHex := line[:hashAlgorithm.Size()*2]
+				pathName := line[hashAlgorithm.Size()*2+1:]
+
+				hashBytes, err := hex.DecodeString(hashHex)
+				if err != nil {
+					printRed("Failed\n")
+					fmt.Println(err)
+					testFailed = true
+					continue
+				}
+
+				expectedEntries[pathName] = GitIndexEntry{Hash: hashBytes}
+			}
+			file.Close()
+
+			ctx := context.WithoutCancel(context.Background())
+			entries, err := ParseGitIndexWithHashAlgorithm(ctx, indexPath, hashAlgorithm)
+			if expectedError == nil && err != nil {
+				printRed("Failed\n")
+				fmt.Println("expected no error, but got: " + err.Error())
+				testFailed = true
+				continue
+			}
+
+			if err != nil && expectedError != nil {
+				if err.Error() != expectedError.Error() {
+					printRed("Failed\n")
+					fmt.Println("expected error text \"" + expectedError.Error() + "\", but got: \"" + err.Error() + "\"")
+					testFailed = true
+					continue
+				}
+			}
+
+			if !gitIndexEntriesMatch(entries, expectedEntries) {
+				printRed("Failed\n")
+
+				fmt.Println("Expected entries:")
+				printEntries(expectedEntries)
+				fmt.Println("But got:")
+				printEntries(entries)
+				testFailed = true
+				continue
+			}
+
+			printGreen("Success\n")
+		}
+	}
+
+	if testFailed {
+		t.Fatal("See above ^")
+	}
+
+	fmt.Println()
+}
+
+func TestParseGitIndexExtendedFlags(t *testing.T) {
+	ctx := context.WithoutCancel(context.Background())
+
+	entries, err := ParseGitIndex(ctx, filepath.Join("tests-index-parser", "version_3", "1_extended_flags", "index"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type TestCase struct {
+		path         string
+		skipWorktree bool
+		intentToAdd  bool
+	}
+
+	tests := []TestCase{
+		{"README.md", false, false},
+		{"dir/file.txt", false, false},
+		{"intent.txt", false, true},
+		{"tracked.txt", true, false},
+	}
+
+	for _, test := range tests {
+		e, ok := entries[test.path]
+		if !ok {
+			t.Fatal("Missing entry:", test.path)
+		}
+
+		if e.SkipWorktree != test.skipWorktree {
+			t.Fatal("Expected SkipWorktree", test.skipWorktree, "for", test.path, "but got:", e.SkipWorktree)
+		}
+
+		if e.IntentToAdd != test.intentToAdd {
+			t.Fatal("Expected IntentToAdd", test.intentToAdd, "for", test.path, "but got:", e.IntentToAdd)
+		}
+	}
+}
+
+// Compares all the entry fields against the output of `git ls-files --stage --debug`
+func TestParseGitIndexFull(t *testing.T) {
+	ctx := context.WithoutCancel(context.Background())
+	testPath := filepath.Join("tests-index-parser", "version_3", "4_full_entries")
+
+	index, err := ParseGitIndexFull(ctx, filepath.Join(testPath, "index"), SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := os.ReadFile(filepath.Join(testPath, "ls-files-stage-debug.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got strings.Builder
+	for _, e := range index.Entries {
+		flags := uint32(e.Flags&^0xfff) | uint32(e.ExtendedFlags)<<16
+
+		fmt.Fprintf(&got, "%06o %s %d\t%s\n", e.Mode, hex.EncodeToString(e.Hash), e.Stage, e.Path)
+		fmt.Fprintf(&got, "  ctime: %d:%d\n", e.MetadataChangedTimeSeconds, e.MetadataChangedTimeNanoSeconds)
+		fmt.Fprintf(&got, "  mtime: %d:%d\n", e.ModifiedTimeSeconds, e.ModifiedTimeNanoSeconds)
+		fmt.Fprintf(&got, "  dev: %d\tino: %d\n", e.Device, e.Inode)
+		fmt.Fprintf(&got, "  uid: %d\tgid: %d\n", e.UserID, e.GroupID)
+		fmt.Fprintf(&got, "  size: %d\tflags: %x\n", e.FileSize, flags)
+	}
+
+	if got.String() != string(expected) {
+		t.Fatal("Expected:\n" + string(expected) + "But got:\n" + got.String())
+	}
+
+	// The fast path should agree with the full entries
+	entries, err := ParseGitIndex(ctx, filepath.Join(testPath, "index"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range index.Entries {
+		if !reflect.DeepEqual(entries[e.Path], e.GitIndexEntry) {
+			t.Fatal("Expected", e.GitIndexEntry, "for", e.Path, "but got:", entries[e.Path])
+		}
+	}
+
+	if !index.Entries[1].AssumeValid || index.Entries[1].Path != "assumed.txt" {
+		t.Fatal("Expected assumed.txt to have AssumeValid set")
+	}
+}
+
+func TestMergeSplitIndex(t *testing.T) {
+	ctx := context.WithoutCancel(context.Background())
+	testPath := filepath.Join("tests-index-parser", "version_2", "13_split_index")
+
+	index, err := ParseGitIndexFull(ctx, filepath.Join(testPath, "index"), SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index, err = MergeSplitIndex(ctx, index, testPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := os.ReadFile(filepath.Join(testPath, "expected_merged.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got strings.Builder
+	for _, e := range index.Entries {
+		got.WriteString(hex.EncodeToString(e.Hash) + " " + e.Path + "\n")
+	}
+
+	if got.String() != string(expected) {
+		t.Fatal("Expected:\n" + string(expected) + "But got:\n" + got.String())
+	}
+
+	entries, err := parseGitIndexMergingSharedIndex(ctx, filepath.Join(testPath, "index"), testPath, SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != len(index.Entries) {
+		t.Fatal("Expected", len(index.Entries), "entries, but got:", len(entries))
+	}
+
+	for _, e := range index.Entries {
+		if !reflect.DeepEqual(entries[e.Path], e.GitIndexEntry) {
+			t.Fatal("Expected", e.GitIndexEntry, "for", e.Path, "but got:", entries[e.Path])
+		}
+	}
+
+	// The shared index is missing here
+	index, err = ParseGitIndexFull(ctx, filepath.Join("tests-index-parser", "version_2", "10_extension_link", "index"), SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := MergeSplitIndex(ctx, index, filepath.Join("tests-index-parser", "version_2", "10_extension_link")); err == nil {
+		t.Fatal("Expected an error for a missing shared index, but got nil")
+	}
+}
+
+func TestParseGitIndexExtensions(t *testing.T) {
+	ctx := context.WithoutCancel(context.Background())
+
+	parse := func(path string) *GitIndex {
+		index, err := ParseGitIndexFull(ctx, filepath.Join("tests-index-parser", filepath.FromSlash(path), "index"), SHA1)
+		if err != nil {
+			t.Fatal("Failed to parse", path, ":", err)
+		}
+		return index
+	}
+
+	hexHash := func(hash []byte) string {
+		return hex.EncodeToString(hash)
+	}
+
+	// TREE and REUC
+	index := parse("version_2/6_extension_tree_reuc")
+	tree := index.Extensions.CacheTree
+	if tree == nil || tree.EntryCount != -1 || tree.Hash != nil || len(tree.Subtrees) != 2 {
+		t.Fatal("Expected an invalidated root cache tree with 2 subtrees, but got:", tree)
+	}
+	if tree.Subtrees[0].Path != "src" || tree.Subtrees[0].EntryCount != 2 || hexHash(tree.Subtrees[0].Hash) != "7511920843a4d00a7268a47c5b6b6f592bf3873a" {
+		t.Fatal("Unexpected cache tree for \"src\":", tree.Subtrees[0])
+	}
+	if len(tree.Subtrees[0].Subtrees) != 1 || tree.Subtrees[0].Subtrees[0].Path != "sub" {
+		t.Fatal("Expected the \"src\" cache tree to have a \"sub\" subtree")
+	}
+
+	if len(index.Extensions.ResolveUndo) != 1 {
+		t.Fatal("Expected 1 resolve-undo entry, but got:", len(index.Extensions.ResolveUndo))
+	}
+	resolveUndo := index.Extensions.ResolveUndo[0]
+	if resolveUndo.Path != "conflict.txt" || resolveUndo.Modes != [3]uint32{0100644, 0100644, 0100644} {
+		t.Fatal("Unexpected resolve-undo entry:", resolveUndo)
+	}
+	if hexHash(resolveUndo.Hashes[0]) != "df967b96a579e45a18b8251732d16804b2e56a55" || hexHash(resolveUndo.Hashes[2]) != "950b81b7eee953d050aa05a641f8e056c85dd1bd" {
+		t.Fatal("Unexpected resolve-undo hashes:", resolveUndo.Hashes)
+	}
+
+	// UNTR
+	untrackedCache := parse("version_2/7_extension_untracked_cache").Extensions.UntrackedCache
+	if untrackedCache == nil || untrackedCache.ExcludePerDir != ".gitignore" || untrackedCache.Root == nil {
+		t.Fatal("Unexpected untracked cache:", untrackedCache)
+	}
+	if !slices.Equal(untrackedCache.Root.Untracked, []string{"w.txt", "scratch/"}) || len(untrackedCache.Root.Subdirectories) != 3 {
+		t.Fatal("Unexpected untracked cache root directory:", untrackedCache.Root)
+	}
+	scratch := untrackedCache.Root.Subdirectories[1]
+	if scratch.Name != "scratch" || !scratch.CheckOnly || !scratch.Valid || !slices.Equal(scratch.Untracked, []string{"u.txt"}) {
+		t.Fatal("Unexpected untracked cache directory:", scratch)
+	}
+
+	// Git writes no root directory until the untracked cache is used for the first time
+	untrackedCache = parse("version_2/14_extension_untracked_cache_no_root").Extensions.UntrackedCache
+	if untrackedCache == nil || untrackedCache.ExcludePerDir != ".gitignore" || untrackedCache.Root != nil {
+		t.Fatal("Unexpected untracked cache without a root directory:", untrackedCache)
+	}
+
+	// FSMN
+	fsmonitor := parse("version_2/8_extension_fsmonitor").Extensions.FSMonitor
+	if fsmonitor == nil || fsmonitor.Version != 2 || fsmonitor.Token != "token-123" {
+		t.Fatal("Unexpected fsmonitor data:", fsmonitor)
+	}
+
+	// EOIE and IEOT
+	index = parse("version_2/9_extension_eoie_ieot")
+	if index.Extensions.EndOfIndexEntry == nil || index.Extensions.EndOfIndexEntry.Offset != 388 {
+		t.Fatal("Unexpected end of index entry:", index.Extensions.EndOfIndexEntry)
+	}
+	offsets := index.Extensions.IndexEntryOffsets
+	if offsets == nil || !slices.Equal(offsets.Blocks, []IndexEntryOffsetBlock{{12, 3}, {236, 2}}) {
+		t.Fatal("Unexpected index entry offset table:", offsets)
+	}
+
+	// link
+	link := parse("version_2/10_extension_link").Extensions.SplitIndex
+	if link == nil || hexHash(link.SharedIndexHash) != "31fcbbff4d9654452775dc661baaf8db7b13c13b" {
+		t.Fatal("Unexpected split index link:", link)
+	}
+	if !link.ReplaceBitmap.IsSet(0) || link.ReplaceBitmap.IsSet(1) || link.DeleteBitmap.IsSet(0) {
+		t.Fatal("Expected only the first shared index entry to be replaced, but got:", link)
+	}
+
+	// sdir
+	index = parse("version_3/3_extension_sparse_directory")
+	if !index.Extensions.SparseDirectories {
+		t.Fatal("Expected a sparse directory extension")
+	}
+	if len(index.Extensions.Unknown) != 0 {
+		t.Fatal("Expected no unknown extensions, but got:", index.Extensions.Unknown)
+	}
+
+	// Unknown extensions are kept as-is. The checksum is not verified here, so we leave it as zeroes.
+	data := []byte("DIRC\x00\x00\x00\x02\x00\x00\x00\x00ABCD\x00\x00\x00\x03xyz")
+	data = append(data, make([]byte, 20)...)
+	index, err := ParseGitIndexFullFromMemory(ctx, data, -1, SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(index.Extensions.Unknown, []UnknownGitIndexExtension{{"ABCD", []byte("xyz")}}) {
+		t.Fatal("Unexpected unknown extensions:", index.Extensions.Unknown)
+	}
+}
+
+func TestReadIndexVarint(t *testing.T) {
+	type TestCase struct {
+		input    []byte
+		expected uint64
+	}
+
+	// Encoded with encode_varint() from Git's varint.c
+	tests := []TestCase{
+		{[]byte{0x00}, 0},
+		{[]byte{0x09}, 9},
+		{[]byte{0x7f}, 127},
+		{[]byte{0x80, 0x00}, 128},
+		{[]byte{0x80, 0x7f}, 255},
+		{[]byte{0xff, 0x7f}, 16511},
+		{[]byte{0x80, 0x80, 0x00}, 16512},
+	}
+
+	for _, test := range tests {
+		got, err := readIndexVarint(bytes.NewReader(test.input))
+		if err != nil {
+			t.Fatal("Unexpected error for", test.input, ":", err)
+		}
+
+		if got != test.expected {
+			t.Fatal("Expected", test.expected, "for", test.input, "but got:", got)
+		}
+	}
+
+	if _, err := readIndexVarint(bytes.NewReader([]byte{0x80})); err == nil {
+		t.Fatal("Expected an error for a truncated varint, but got nil")
+	}
+}
+
+func TestReadHashAlgorithm(t *testing.T) {
+	type TestCase struct {
+		config      string // Empty means there is no config file
+		expected    HashAlgorithm
+		expectError bool
+	}
+
+	tests := []TestCase{
+		{"", SHA1, false},
+		{"[core]\n\trepositoryformatversion = 0\n", SHA1, false},
+		{"[core]\n\trepositoryformatversion = 1\n[extensions]\n\tobjectformat = sha256\n", SHA256, false},
+		{"[Extensions]\n\tObjectFormat = sha1 ; comment\n", SHA1, false},
+		{"[core]\n\tobjectformat = sha256\n", SHA1, false}, // Not in the extensions section
+		{"[extensions]\n\tobjectformat = md5\n", SHA1, true},
+	}
+
+	for i, test := range tests {
+		dir := t.TempDir()
+		if test.config != "" {
+			if err := os.WriteFile(filepath.Join(dir, "config"), []byte(test.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		got, err := ReadHashAlgorithm(dir)
+		if test.expectError {
+			if err == nil {
+				t.Fatal("Expected an error in test index", i, "but got nil")
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatal("Expected no error in test index", i, "but got:", err)
+		}
+
+		if got != test.expected {
+			t.Fatal("Expected", test.expected, "in test index", i, "but got:", got)
+		}
+	}
+}
+
+func TestWildmatch(t *testing.T) {
+	type TestCase struct {
+		glob       int // With wildmatchPathname
+		iglob      int // With wildmatchPathname and wildmatchCaseFold
+		pathmatch  int // Without flags
+		ipathmatch int // With wildmatchCaseFold
+		text       string
+		pattern    string
+	}
+
+	// The test cases of Git, see: https://github.com/git/git/blob/ef8ce8f3d4344fd3af049c17eeba5cd20d98b69f/t/t3070-wildmatch.sh
+	tests := []TestCase{
+		// Basic wildmatch features
+		{1, 1, 1, 1, "foo", "foo"},
+		{0, 0, 0, 0, "foo", "bar"},
+		{1, 1, 1, 1, "", ""},
+		{1, 1, 1, 1, "foo", "???"},
+		{0, 0, 0, 0, "foo", "??"},
+		{1, 1, 1, 1, "foo", "*"},
+		{1, 1, 1, 1, "foo", "f*"},
+		{0, 0, 0, 0, "foo", "*f"},
+		{1, 1, 1, 1, "foo", "*foo*"},
+		{1, 1, 1, 1, "foobar", "*ob*a*r*"},
+		{1, 1, 1, 1, "aaaaaaabababab", "*ab"},
+		{1, 1, 1, 1, "foo*", "foo\\*"},
+		{0, 0, 0, 0, "foobar", "foo\\*bar"},
+		{1, 1, 1, 1, "f\\oo", "f\\\\oo"},
+		{1, 1, 1, 1, "ball", "*[al]?"},
+		{0, 0, 0, 0, "ten", "[ten]"},
+		{1, 1, 1, 1, "ten", "**[!te]"},
+		{0, 0, 0, 0, "ten", "**[!ten]"},
+		{1, 1, 1, 1, "ten", "t[a-g]n"},
+		{0, 0, 0, 0, "ten", "t[!a-g]n"},
+		{1, 1, 1, 1, "ton", "t[!a-g]n"},
+		{1, 1, 1, 1, "ton", "t[^a-g]n"},
+		{1, 1, 1, 1, "a]b", "a[]]b"},
+		{1, 1, 1, 1, "a-b", "a[]-]b"},
+		{1, 1, 1, 1, "a]b", "a[]-]b"},
+		{0, 0, 0, 0, "aab", "a[]-]b"},
+		{1, 1, 1, 1, "aab", "a[]a-]b"},
+		{1, 1, 1, 1, "]", "]"},
+
+		// Extended slash-matching features
+		{0, 0, 1, 1, "foo/baz/bar", "foo*bar"},
+		{0, 0, 1, 1, "foo/baz/bar", "foo**bar"},
+		{1, 1, 1, 1, "foobazbar", "foo**bar"},
+		{1, 1, 1, 1, "foo/baz/bar", "foo/**/bar"},
+		{1, 1, 0, 0, "foo/baz/bar", "foo/**/**/bar"},
+		{1, 1, 1, 1, "foo/b/a/z/bar", "foo/**/bar"},
+		{1, 1, 1, 1, "foo/b/a/z/bar", "foo/**/**/bar"},
+		{1, 1, 0, 0, "foo/bar", "foo/**/bar"},
+		{1, 1, 0, 0, "foo/bar", "foo/**/**/bar"},
+		{0, 0, 1, 1, "foo/bar", "foo?bar"},
+		{0, 0, 1, 1, "foo/bar", "foo[/]bar"},
+		{0, 0, 1, 1, "foo/bar", "foo[^a-z]bar"},
+		{0, 0, 1, 1, "foo/bar", "f[^eiu][^eiu][^eiu][^eiu][^eiu]r"},
+		{1, 1, 1, 1, "foo-bar", "f[^eiu][^eiu][^eiu][^eiu][^eiu]r"},
+		{1, 1, 0, 0, "foo", "**/foo"},
+		{1, 1, 1, 1, "XXX/foo", "**/foo"},
+		{1, 1, 1, 1, "bar/baz/foo", "**/foo"},
+		{0, 0, 1, 1, "bar/baz/foo", "*/foo"},
+		{0, 0, 1, 1, "foo/bar/baz", "**/bar*"},
+		{1, 1, 1, 1, "deep/foo/bar/baz", "**/bar/*"},
+		{0, 0, 1, 1, "deep/foo/bar/baz/", "**/bar/*"},
+		{1, 1, 1, 1, "deep/foo/bar/baz/", "**/bar/**"},
+		{0, 0, 0, 0, "deep/foo/bar", "**/bar/*"},
+		{1, 1, 1, 1, "deep/foo/bar/", "**/bar/**"},
+		{0, 0, 1, 1, "foo/bar/baz", "**/bar**"},
+		{1, 1, 1, 1, "foo/bar/baz/x", "*/bar/**"},
+		{0, 0, 1, 1, "deep/foo/bar/baz/x", "*/bar/**"},
+		{1, 1, 1, 1, "deep/foo/bar/baz/x", "**/bar/*/*"},
+
+		// Various additional tests
+		{0, 0, 0, 0, "acrt", "a[c-c]st"},
+		{1, 1, 1, 1, "acrt", "a[c-c]rt"},
+		{0, 0, 0, 0, "]", "[!]-]"},
+		{1, 1, 1, 1, "a", "[!]-]"},
+		{0, 0, 0, 0, "", "\\"},
+		{0, 0, 0, 0, "\\", "\\"},
+		{0, 0, 0, 0, "XXX/\\", "*/\\"},
+		{1, 1, 1, 1, "XXX/\\", "*/\\\\"},
+		{1, 1, 1, 1, "foo", "foo"},
+		{1, 1, 1, 1, "@foo", "@foo"},
+		{0, 0, 0, 0, "foo", "@foo"},
+		{1, 1, 1, 1, "[ab]", "\\[ab]"},
+		{1, 1, 1, 1, "[ab]", "[[]ab]"},
+		{1, 1, 1, 1, "[ab]", "[[:]ab]"},
+		{0, 0, 0, 0, "[ab]", "[[::]ab]"},
+		{1, 1, 1, 1, "[ab]", "[[:digit]ab]"},
+		{1, 1, 1, 1, "[ab]", "[\\[:]ab]"},
+		{1, 1, 1, 1, "?a?b", "\\??\\?b"},
+		{1, 1, 1, 1, "abc", "\\a\\b\\c"},
+		{0, 0, 0, 0, "foo", ""},
+		{1, 1, 1, 1, "foo/bar/baz/to", "**/t[o]"},
+
+		// Character class tests
+		{1, 1, 1, 1, "a1B", "[[:alpha:]][[:digit:]][[:upper:]]"},
+		{0, 1, 0, 1, "a", "[[:digit:][:upper:][:space:]]"},
+		{1, 1, 1, 1, "A", "[[:digit:][:upper:][:space:]]"},
+		{1, 1, 1, 1, "1", "[[:digit:][:upper:][:space:]]"},
+		{0, 0, 0, 0, "1", "[[:digit:][:upper:][:spaci:]]"},
+		{1, 1, 1, 1, " ", "[[:digit:][:upper:][:space:]]"},
+		{0, 0, 0, 0, ".", "[[:digit:][:upper:][:space:]]"},
+		{1, 1, 1, 1, ".", "[[:digit:][:punct:][:space:]]"},
+		{1, 1, 1, 1, "5", "[[:xdigit:]]"},
+		{1, 1, 1, 1, "f", "[[:xdigit:]]"},
+		{1, 1, 1, 1, "D", "[[:xdigit:]]"},
+		{1, 1, 1, 1, "_", "[[:alnum:][:alpha:][:blank:][:cntrl:][:digit:][:graph:][:lower:][:print:][:punct:][:space:][:upper:][:xdigit:]]"},
+		{1, 1, 1, 1, ".", "[^[:alnum:][:alpha:][:blank:][:cntrl:][:digit:][:lower:][:space:][:upper:][:xdigit:]]"},
+		{1, 1, 1, 1, "5", "[a-c[:digit:]x-z]"},
+		{1, 1, 1, 1, "b", "[a-c[:digit:]x-z]"},
+		{1, 1, 1, 1, "y", "[a-c[:digit:]x-z]"},
+		{0, 0, 0, 0, "q", "[a-c[:digit:]x-z]"},
+
+		// Additional tests, including some malformed wildmatch patterns
+		{1, 1, 1, 1, "]", "[\\\\-^]"},
+		{0, 0, 0, 0, "[", "[\\\\-^]"},
+		{1, 1, 1, 1, "-", "[\\-_]"},
+		{1, 1, 1, 1, "]", "[\\]]"},
+		{0, 0, 0, 0, "\\]", "[\\]]"},
+		{0, 0, 0, 0, "\\", "[\\]]"},
+		{0, 0, 0, 0, "ab", "a[]b"},
+		{0, 0, 0, 0, "a[]b", "a[]b"},
+		{0, 0, 0, 0, "ab[", "ab["},
+		{0, 0, 0, 0, "ab", "[!"},
+		{0, 0, 0, 0, "ab", "[-"},
+		{1, 1, 1, 1, "-", "[-]"},
+		{0, 0, 0, 0, "-", "[a-"},
+		{0, 0, 0, 0, "-", "[!a-"},
+		{1, 1, 1, 1, "-", "[--A]"},
+		{1, 1, 1, 1, "5", "[--A]"},
+		{1, 1, 1, 1, " ", "[ --]"},
+		{1, 1, 1, 1, "$", "[ --]"},
+		{1, 1, 1, 1, "-", "[ --]"},
+		{0, 0, 0, 0, "0", "[ --]"},
+		{1, 1, 1, 1, "-", "[---]"},
+		{1, 1, 1, 1, "-", "[------]"},
+		{0, 0, 0, 0, "j", "[a-e-n]"},
+		{1, 1, 1, 1, "-", "[a-e-n]"},
+		{1, 1, 1, 1, "a", "[!------]"},
+		{0, 0, 0, 0, "[", "[]-a]"},
+		{1, 1, 1, 1, "^", "[]-a]"},
+		{0, 0, 0, 0, "^", "[!]-a]"},
+		{1, 1, 1, 1, "[", "[!]-a]"},
+		{1, 1, 1, 1, "^", "[a^bc]"},
+		{1, 1, 1, 1, "-b]", "[a-]b]"},
+		{0, 0, 0, 0, "\\", "[\\]"},
+		{1, 1, 1, 1, "\\", "[\\\\]"},
+		{0, 0, 0, 0, "\\", "[!\\\\]"},
+		{1, 1, 1, 1, "G", "[A-\\\\]"},
+		{0, 0, 0, 0, "aaabbb", "b*a"},
+		{0, 0, 0, 0, "aabcaa", "*ba*"},
+		{1, 1, 1, 1, ",", "[,]"},
+		{1, 1, 1, 1, ",", "[\\\\,]"},
+		{1, 1, 1, 1, "\\", "[\\\\,]"},
+		{1, 1, 1, 1, "-", "[,-.]"},
+		{0, 0, 0, 0, "+", "[,-.]"},
+		{0, 0, 0, 0, "-.]", "[,-.]"},
+		{1, 1, 1, 1, "2", "[\\1-\\3]"},
+		{1, 1, 1, 1, "3", "[\\1-\\3]"},
+		{0, 0, 0, 0, "4", "[\\1-\\3]"},
+		{1, 1, 1, 1, "\\", "[[-\\]]"},
+		{1, 1, 1, 1, "[", "[[-\\]]"},
+		{1, 1, 1, 1, "]", "[[-\\]]"},
+		{0, 0, 0, 0, "-", "[[-\\]]"},
+
+		// Test recursion
+		{1, 1, 1, 1, "-adobe-courier-bold-o-normal--12-120-75-75-m-70-iso8859-1", "-*-*-*-*-*-*-12-*-*-*-m-*-*-*"},
+		{0, 0, 0, 0, "-adobe-courier-bold-o-normal--12-120-75-75-X-70-iso8859-1", "-*-*-*-*-*-*-12-*-*-*-m-*-*-*"},
+		{0, 0, 0, 0, "-adobe-courier-bold-o-normal--12-120-75-75-/-70-iso8859-1", "-*-*-*-*-*-*-12-*-*-*-m-*-*-*"},
+		{1, 1, 1, 1, "XXX/adobe/courier/bold/o/normal//12/120/75/75/m/70/iso8859/1", "XXX/*/*/*/*/*/*/12/*/*/*/m/*/*/*"},
+		{0, 0, 0, 0, "XXX/adobe/courier/bold/o/normal//12/120/75/75/X/70/iso8859/1", "XXX/*/*/*/*/*/*/12/*/*/*/m/*/*/*"},
+		{1, 1, 1, 1, "abcd/abcdefg/abcdefghijk/abcdefghijklmnop.txt", "**/*a*b*g*n*t"},
+		{0, 0, 0, 0, "abcd/abcdefg/abcdefghijk/abcdefghijklmnop.txtz", "**/*a*b*g*n*t"},
+		{0, 0, 0, 0, "foo", "*/*/*"},
+		{0, 0, 0, 0, "foo/bar", "*/*/*"},
+		{1, 1, 1, 1, "foo/bba/arr", "*/*/*"},
+		{0, 0, 1, 1, "foo/bb/aa/rr", "*/*/*"},
+		{1, 1, 1, 1, "foo/bb/aa/rr", "**/**/**"},
+		{1, 1, 1, 1, "abcXdefXghi", "*X*i"},
+		{0, 0, 1, 1, "ab/cXd/efXg/hi", "*X*i"},
+		{1, 1, 1, 1, "ab/cXd/efXg/hi", "*/*X*/*/*i"},
+		{1, 1, 1, 1, "ab/cXd/efXg/hi", "**/*X*/**/*i"},
+
+		// Extra pathmatch tests
+		{0, 0, 0, 0, "foo", "fo"},
+		{1, 1, 1, 1, "foo/bar", "foo/bar"},
+		{1, 1, 1, 1, "foo/bar", "foo/*"},
+		{0, 0, 1, 1, "foo/bba/arr", "foo/*"},
+		{1, 1, 1, 1, "foo/bba/arr", "foo/**"},
+		{0, 0, 1, 1, "foo/bba/arr", "foo*"},
+		{0, 0, 1, 1, "foo/bba/arr", "foo**"},
+		{0, 0, 1, 1, "foo/bba/arr", "foo/*arr"},
+		{0, 0, 1, 1, "foo/bba/arr", "foo/**arr"},
+		{0, 0, 0, 0, "foo/bba/arr", "foo/*z"},
+		{0, 0, 0, 0, "foo/bba/arr", "foo/**z"},
+		{0, 0, 1, 1, "foo/bar", "foo?bar"},
+		{0, 0, 1, 1, "foo/bar", "foo[/]bar"},
+		{0, 0, 1, 1, "foo/bar", "foo[^a-z]bar"},
+		{0, 0, 1, 1, "ab/cXd/efXg/hi", "*Xg*i"},
+
+		// Extra case-sensitivity tests
+		{0, 1, 0, 1, "a", "[A-Z]"},
+		{1, 1, 1, 1, "A", "[A-Z]"},
+		{0, 1, 0, 1, "A", "[a-z]"},
+		{1, 1, 1, 1, "a", "[a-z]"},
+		{0, 1, 0, 1, "a", "[[:upper:]]"},
+		{1, 1, 1, 1, "A", "[[:upper:]]"},
+		{0, 1, 0, 1, "A", "[[:lower:]]"},
+		{1, 1, 1, 1, "a", "[[:lower:]]"},
+		{0, 1, 0, 1, "A", "[B-Za]"},
+		{1, 1, 1, 1, "a", "[B-Za]"},
+		{0, 1, 0, 1, "A", "[B-a]"},
+		{1, 1, 1, 1, "a", "[B-a]"},
+		{0, 1, 0, 1, "z", "[Z-y]"},
+		{1, 1, 1, 1, "Z", "[Z-y]"},
+	}
+
+	for _, test := range tests {
+		expected := []int{test.glob, test.iglob, test.pathmatch, test.ipathmatch}
+		flags := []int{wildmatchPathname, wildmatchPathname | wildmatchCaseFold, 0, wildmatchCaseFold}
+		for i := range flags {
+			if wildmatch(test.pattern, test.text, flags[i]) != (expected[i] == 1) {
+				t.Fatal("Expected", expected[i], "for pattern", strconv.Quote(test.pattern), "and text", strconv.Quote(test.text), "with flags", flags[i])
+			}
+		}
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	type TestCase struct {
+		config      string
+		key         string
+		expected    []string // All the values of key
+		expectError bool
+	}
+
+	tests := []TestCase{
+		{"[core]\n\tfileMode = false\n", "core.filemode", []string{"false"}, false},
+		{"[Core]\nFILEMODE=false", "CORE.fileMode", []string{"false"}, false}, // No newline at the end
+		{"[core]\n\tbare\n", "core.bare", []string{""}, false},
+		{"[core] bare = true\n", "core.bare", []string{"true"}, false}, // Value on the same line as the section
+		{"[remote \"origin\"]\n\turl = a\n", "remote.origin.url", []string{"a"}, false},
+		{"[remote \"origin\"]\n\turl = a\n", "remote.Origin.url", nil, false}, // Subsections are case-sensitive
+		{"[remote \"a\\\"b\\\\c\"]\n\turl = a\n", "remote.a\"b\\c.url", []string{"a"}, false},
+		{"[remote.Origin]\n\turl = a\n", "remote.origin.url", []string{"a"}, false}, // Deprecated syntax is lowercased
+		{"[a]\n\tb = 1\n\tb = 2\n[c]\n\tb = 3\n[a]\n\tb = 4\n", "a.b", []string{"1", "2", "4"}, false},
+		{"[a]\n\tb =   one  two   \n", "a.b", []string{"one  two"}, false},
+		{"[a]\n\tb = \"  quoted  \" # comment\n", "a.b", []string{"  quoted  "}, false},
+		{"[a]\n\tb = x;comment\n", "a.b", []string{"x"}, false},
+		{"[a]\n\tb = \"x;not a comment\"\n", "a.b", []string{"x;not a comment"}, false},
+		{"[a]\n\tb = 1\\t2\\n3\\\\4\\\"5\n", "a.b", []string{"1\t2\n3\\4\"5"}, false},
+		{"[a]\n\tb = one \\\n two\n", "a.b", []string{"one  two"}, false}, // Line continuation
+		{"[a]\r\n\tb = crlf\r\n", "a.b", []string{"crlf"}, false},
+		{"\xef\xbb\xbf[a]\n\tb = bom\n", "a.b", []string{"bom"}, false},
+		{"# comment\n; comment\n[a] ; comment\n\tb = c\n", "a.b", []string{"c"}, false},
+		{"[a]\n\tb = \\x\n", "a.b", nil, true},     // Unknown escape
+		{"[a]\n\tb = \"open\n", "a.b", nil, true},  // Unclosed quote
+		{"b = c\n", "a.b", nil, true},              // No section
+		{"[a\n\tb = c\n", "a.b", nil, true},        // Unclosed section
+		{"[a]\n\t1b = c\n", "a.b", nil, true},      // Keys start with a letter
+		{"[a]\n\tb c\n", "a.b", nil, true},         // Missing "="
+		{"[a \"b]\n\tc = d\n", "a.b.c", nil, true}, // Unclosed subsection
+	}
+
+	for i, test := range tests {
+		config := &Config{}
+		err := config.parse([]byte(test.config), "config", "", -1)
+		if test.expectError {
+			if err == nil {
+				t.Fatal("Expected an error in test index", i, "but got nil")
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatal("Expected no error in test index", i, "but got:", err)
+		}
+
+		if got := config.GetAll(test.key); !slices.Equal(got, test.expected) {
+			t.Fatal("Expected", test.expected, "for", test.key, "in test index", i, "but got:", got)
+		}
+	}
+
+	config := &Config{}
+	if err := config.parse([]byte("[a]\n\tyes\n\tno = off\n\tnum = 2\n\tbad = maybe\n"), "config", "", -1); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, expected := range map[string]bool{"a.yes": true, "a.no": false, "a.num": true} {
+		if value, found, err := config.GetBool(key); err != nil || !found || value != expected {
+			t.Fatal("Expected", expected, "for", key, "but got:", value, found, err)
+		}
+	}
+
+	if _, _, err := config.GetBool("a.bad"); err == nil {
+		t.Fatal("Expected an error for a.bad")
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	dir := t.TempDir()
+	write := func(path, content string) {
+		path = filepath.Join(dir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Setenv("GIT_CONFIG_SYSTEM", filepath.Join(dir, "system"))
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "")
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(dir, "global"))
+
+	workDir := filepath.ToSlash(filepath.Join(dir, "work")) + "/"
+	write("system", "[test]\n\tsystem = 1\n\tlayer = system\n")
+	write("global", "[test]\n\tlayer = global\n[include]\n\tpath = included\n[includeIf \"gitdir:"+workDir+"\"]\n\tpath = work.inc\n[includeIf \"gitdir:/nonexistent/\"]\n\tpath = never.inc\n[includeIf \"gitdir/i:**/WORK/REPO/.GIT\"]\n\tpath = icase.inc\n[includeIf \"onbranch:feature/\"]\n\tpath = branch.inc\n")
+	write("included", "[test]\n\tincluded = 1\n\tlayer = included\n")
+	write("work.inc", "[test]\n\twork = 1\n")
+	write("never.inc", "[test]\n\tnever = 1\n")
+	write("icase.inc", "[test]\n\ticase = 1\n")
+	write("branch.inc", "[test]\n\tbranch = 1\n")
+	write("work/repo/.git/config", "[test]\n\tlayer = repo\n[extensions]\n\tworktreeConfig = true\n")
+	write("work/repo/.git/config.worktree", "[test]\n\tworktree = 1\n")
+	write("work/repo/.git/HEAD", "ref: refs/heads/feature/x\n")
+	write("loop", "[include]\n\tpath = loop\n")
+
+	gitDir := filepath.Join(dir, "work", "repo", ".git")
+	config, err := ReadConfig(&Repository{WorkTree: filepath.Dir(gitDir), GitDir: gitDir, CommonDir: gitDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string][]string{
+		"test.system":   {"1"},
+		"test.layer":    {"system", "global", "included", "repo"},
+		"test.included": {"1"},
+		"test.work":     {"1"},
+		"test.never":    nil,
+		"test.icase":    {"1"},
+		"test.branch":   {"1"},
+		"test.worktree": {"1"},
+	}
+
+	for key, values := range expected {
+		if got := config.GetAll(key); !slices.Equal(got, values) {
+			t.Fatal("Expected", values, "for", key, "but got:", got)
+		}
+	}
+
+	if value, _ := config.Get("test.layer"); value != "repo" {
+		t.Fatal("Expected the repository config to override the others, but got:", value)
+	}
+
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "true")
+	config, err = ReadConfig(&Repository{WorkTree: filepath.Dir(gitDir), GitDir: gitDir, CommonDir: gitDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := config.Get("test.system"); found {
+		t.Fatal("Expected the system config to be skipped with GIT_CONFIG_NOSYSTEM")
+	}
+
+	if _, err := ReadConfigFile(filepath.Join(dir, "loop")); err == nil {
+		t.Fatal("Expected an error for an include loop")
+	}
+}
+
+func TestSparseCheckoutIncludes(t *testing.T) {
+	type TestCase struct {
+		config   string
+		patterns string
+		path     string
+		expected bool
+	}
+
+	const cone = "[core]\n\tsparseCheckout = true\n\tsparseCheckoutCone = true\n"
+	const noCone = "[core]\n\tsparseCheckout\n"
+	const conePatterns = "/*\n!/*/\n/a/\n!/a/*/\n/a/b/\n/c\\*d/\n"
+
+	tests := []TestCase{
+		{cone, conePatterns, "root.txt", true},
+		{cone, conePatterns, "a/file.txt", true},         // Directly inside a parent directory
+		{cone, conePatterns, "a/other/file.txt", false},  // Not recursive in a parent directory
+		{cone, conePatterns, "a/b/c/d/file.txt", true},   // Recursive directory
+		{cone, conePatterns, "c*d/file.txt", true},       // Escaped wildcard
+		{cone, conePatterns, "outside/file.txt", false},  // Not in the cone
+		{cone, "/*\n!/*/\n/a*/\n", "abc/file.txt", true}, // Wildcards fall back to non-cone mode
+		{cone, "/*\n!/*/\n/a*/\n", "other/file.txt", false},
+		{noCone, "*.txt\n!dir/\n", "dir/file.txt", true}, // The pattern matching the file wins over its excluded parent directory
+		{noCone, "*.txt\n!dir/\n", "other/file.txt", true},
+		{noCone, "*.txt\n!dir/\n", "file.md", false},
+		{noCone, "/*\n!/docs/\n/docs/keep.md\n", "docs/keep.md", true}, // Re-included under an excluded parent directory
+		{noCone, "/*\n!/docs/\n/docs/keep.md\n", "docs/other.md", false},
+		{noCone, "docs/\n!docs/x.md\n", "docs/keep.md", true}, // Included by its parent directory
+		{noCone, "docs/\n!docs/x.md\n", "docs/x.md", false},
+		{noCone, "/////\n*.md\n", "root.txt", false}, // "/////" never matches anything
+		{noCone, "/////\n*.md\n", "docs/keep.md", true},
+		{"[core]\n\tsparseCheckout = false\n", "/a/\n", "file.md", true}, // Not enabled
+	}
+
+	for i, test := range tests {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "config"), []byte(test.config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Mkdir(filepath.Join(dir, "info"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "info", "sparse-checkout"), []byte(test.patterns), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		config, err := ReadConfigFile(filepath.Join(dir, "config"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sparse, err := readSparseCheckout(dir, config)
+		if err != nil {
+			t.Fatal("Expected no error in test index", i, "but got:", err)
+		}
+
+		if got := sparse.includes(test.path); got != test.expected {
+			t.Fatal("Expected", test.expected, "for", test.path, "in test index", i, "but got:", got)
+		}
+	}
+}
+
+func TestIncludingDirectories(t *testing.T) {
+	c := func(path string) string {
+		return filepath.FromSlash(path)
+	}
+
+	changedFiles := map[string]ChangedFile{
+		c("main.go"):        {},
+		c("screenshots/hi"): {},
+	}
+
+	expected := map[string]ChangedFile{
+		c("main.go"):        {},
+		c("screenshots/hi"): {},
+		c("screenshots"):    {},
+	}
+
+	got := IncludingDirectories(changedFiles)
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatal("TestIncludingDirectories (1) got an unexpected value")
+	}
+
+	changedFiles = map[string]ChangedFile{
+		c("main.go"):                        {},
+		c("screenshots/hi"):                 {},
+		c("folder/anotherfolder/hello.txt"): {},
+		c("folder/anotherfolder/hi"):        {},
+		c("folder/file.txt"):                {},
+		c("folder/anotherfile.txt"):         {},
+	}
+
+	expected = map[string]ChangedFile{
+		c("main.go"):                        {},
+		c("screenshots/hi"):                 {},
+		c("folder/anotherfolder/hello.txt"): {},
+		c("folder/anotherfolder/hi"):        {},
+		c("folder/file.txt"):                {},
+		c("folder/anotherfile.txt"):         {},
+
+		c("screenshots"):          {},
+		c("folder/anotherfolder"): {},
+		c("folder"):               {},
+	}
+
+	got = IncludingDirectories(changedFiles)
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatal("TestIncludingDirectories (2) got an unexpected value")
+	}
+
+	empty := map[string]ChangedFile{}
+	got = IncludingDirectories(empty)
+	if !reflect.DeepEqual(got, empty) {
+		t.Fatal("TestIncludingDirectories expected no values, but got some")
+	}
+
+	changedFiles = map[string]ChangedFile{
+		c("many/folders/for/sure/oh/yeah.txt"): {},
+	}
+	expected = map[string]ChangedFile{
+		c("many"):                              {},
+		c("many/folders"):                      {},
+		c("many/folders/for"):                  {},
+		c("many/folders/for/sure"):             {},
+		c("many/folders/for/sure/oh"):          {},
+		c("many/folders/for/sure/oh/yeah.txt"): {},
+	}
+	got = IncludingDirectories(changedFiles)
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatal("TestIncludingDirectories did not recursively add directories to the map")
+	}
+
+	changedFiles = map[string]ChangedFile{
+		c("main.go"):        {},
+		c("screenshots/hi"): {},
+	}
+	expected = map[string]ChangedFile{
+		c("main.go"):        {},
+		c("screenshots/hi"): {},
+	}
+	IncludingDirectories(changedFiles)
+	if !reflect.DeepEqual(changedFiles, expected) {
+		t.Fatal("TestIncludingDirectories overwrote the input argument!")
 	}
 }
 
@@ -655,6 +2081,50 @@ func TestParseGitIndexFromMemoryUntrustedAllocationCount(t *testing.T) {
 	}
 }
 
+func TestParseGitIndexCorrupt(t *testing.T) {
+	ctx := context.WithoutCancel(context.Background())
+
+	data, err := os.ReadFile(filepath.Join("tests-index-parser", "version_2", "1_valid", "index"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type TestCase struct {
+		data       []byte
+		offset     int
+		entryIndex int
+	}
+
+	flipped := bytes.Clone(data)
+	flipped[0x3a] ^= 1
+
+	tests := []TestCase{
+		{data[:200], 196, 2},                     // Truncated in the hash of the 3rd entry
+		{data[:len(data)-1], len(data) - 21, -1}, // Truncated in the checksum
+		{flipped, len(data) - 20, -1},            // Bit flipped in the hash of the 1st entry
+	}
+
+	for i, test := range tests {
+		_, err := ParseGitIndexFromMemory(ctx, test.data, -1)
+
+		var corruptErr *IndexCorruptError
+		if !errors.As(err, &corruptErr) {
+			t.Fatal("Test", i, "expected an *IndexCorruptError, but got:", err)
+		}
+
+		if corruptErr.Offset != test.offset || corruptErr.EntryIndex != test.entryIndex {
+			t.Fatal("Test", i, "expected offset", test.offset, "and entry index", test.entryIndex, "but got:", corruptErr)
+		}
+	}
+
+	// An all-zero checksum is written by Git when index.skipHash is enabled
+	skipHash := bytes.Clone(data)
+	clear(skipHash[len(skipHash)-20:])
+	if _, err := ParseGitIndexFullFromMemory(ctx, skipHash, -1, SHA1); err != nil {
+		t.Fatal("Expected no error for an all-zero checksum, but got:", err)
+	}
+}
+
 // Fuzz for crashes in ParseGitIndexFromMemory()
 func FuzzParseGitIndexFromMemory(f *testing.F) {
 	files, err := os.ReadDir("test-data" + string(os.PathSeparator) + "fuzz_indexes")
@@ -674,6 +2144,7 @@ func FuzzParseGitIndexFromMemory(f *testing.F) {
 	f.Fuzz(func(t *testing.T, data []byte) {
 		ctx := context.WithoutCancel(context.Background())
 		_, _ = ParseGitIndexFromMemory(ctx, data, 1000)
+		_, _ = ParseGitIndexFullFromMemory(ctx, data, 1000, SHA1)
 	})
 }
 
@@ -773,8 +2244,9 @@ func TestUntrackedPathsNotIgnoredWorker(t *testing.T) {
 			printGreen(" Success\n")
 		}
 	}()
-	ignoreObj := ignore.CompileIgnoreLines("ignored_folder/")
-	ignoresCache := map[string]*ignore.GitIgnore{".": ignoreObj}
+	rules := &ignoreRules{folders: map[string]*ignoreFile{
+		"": parseIgnoreFile([]byte("ignored_folder/"), ".gitignore", "", &statusSettings{}),
+	}}
 	indexEntries := make(map[string]GitIndexEntry) // Empty
 	gitLinkPaths := make([]string, 0)              // Empty
 
@@ -787,7 +2259,7 @@ func TestUntrackedPathsNotIgnoredWorker(t *testing.T) {
 		slices := spreadArrayIntoSlicesForGoroutines(len(paths), num)
 		for threadIdx, slice := range slices {
 			ourSlice := paths[slice.start : slice.start+slice.length]
-			results[threadIdx] = untrackedPathsNotIgnoredWorker(ctx, ourSlice, ignoresCache, indexEntries, gitLinkPaths, true)
+			results[threadIdx] = untrackedPathsNotIgnoredWorker(ctx, ourSlice, rules, indexEntries, gitLinkPaths, true, &statusSettings{}, IGNORED_NO, nil)
 		}
 
 		// Merge the results
@@ -945,7 +2417,7 @@ func TestBenchmarkHashMatches(t *testing.T) {
 
 	start := time.Now()
 	for i := 0; i < howManyTimes; i++ {
-		hashMatches(hash, data)
+		hashMatches(SHA1, hash, data)
 	}
 	duration := time.Since(start)
 	fmt.Println(" " + duration.String())
@@ -0,0 +1,380 @@
+package gogitstatus
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// A pattern from an ignore file, parsed like Git does in parse_path_pattern()
+// https://github.com/git/git/blob/ef8ce8f3d4344fd3af049c17eeba5cd20d98b69f/dir.c
+type ignorePattern struct {
+	pattern   string // Without the leading "!" and the trailing "/"
+	text      string // The line as written in the ignore file, without trailing spaces
+	line      int    // Starting at 1
+	negative  bool   // Starts with "!", un-ignores the paths it matches
+	mustBeDir bool   // Ends with "/", only matches folders
+	noDir     bool   // Has no "/" other than a trailing one, matched against the file name only
+}
+
+// The patterns of an ignore file, like a .gitignore file or $GIT_DIR/info/exclude
+type ignoreFile struct {
+	path     string // Where the file was read from
+	base     string // The folder the patterns are relative to (relative to the work tree), empty for the root or ends in a '/'
+	patterns []ignorePattern
+}
+
+// Removes the trailing spaces which aren't escaped with a backslash, like trim_trailing_spaces() in Git
+func trimTrailingSpaces(line string) string {
+	lastSpace := -1
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case ' ':
+			if lastSpace == -1 {
+				lastSpace = i
+			}
+		case '\\':
+			i++
+			if i == len(line) {
+				return line
+			}
+			lastSpace = -1
+		default:
+			lastSpace = -1
+		}
+	}
+
+	if lastSpace != -1 {
+		return line[:lastSpace]
+	}
+	return line
+}
+
+// Parses the patterns of an ignore file, base is the folder they are relative to.
+// With settings.precomposeUnicode, the patterns are precomposed (NFC) like the paths matched against them.
+func parseIgnoreFile(data []byte, path, base string, settings *statusSettings) *ignoreFile {
+	file := &ignoreFile{path: path, base: base}
+
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if settings.precomposeUnicode {
+		data = norm.NFC.Bytes(data)
+	}
+
+	for i, line := range strings.Split(string(data), "\n") {
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		line = strings.TrimSuffix(line, "\r")
+
+		// Git reads the patterns as C strings, so they end at the first null byte
+		if nullByte := strings.IndexByte(line, 0); nullByte != -1 {
+			line = line[:nullByte]
+		}
+
+		line = trimTrailingSpaces(line)
+
+		p := ignorePattern{text: line, line: i + 1}
+		pattern := line
+		if strings.HasPrefix(pattern, "!") {
+			p.negative = true
+			pattern = pattern[1:]
+		}
+		if strings.HasSuffix(pattern, "/") {
+			p.mustBeDir = true
+			pattern = pattern[:len(pattern)-1]
+		}
+
+		// Never matches anything
+		if pattern == "" {
+			continue
+		}
+
+		p.noDir = !strings.Contains(pattern, "/")
+		p.pattern = strings.TrimPrefix(pattern, "/")
+		file.patterns = append(file.patterns, p)
+	}
+
+	return file
+}
+
+// Reads and parses an ignore file. With noFollow, a symbolic link is not followed like Git does for .gitignore files in the work tree.
+func readIgnoreFile(path, base string, noFollow bool, settings *statusSettings) (*ignoreFile, error) {
+	if noFollow {
+		stat, err := os.Lstat(path)
+		if err != nil {
+			return nil, err
+		}
+		if stat.Mode()&fs.ModeSymlink != 0 {
+			return nil, errors.New("unable to access '" + path + "': is a symbolic link")
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseIgnoreFile(data, path, base, settings), nil
+}
+
+// Returns the last pattern of file matching path (a "/"-separated path relative to the work tree), or nil if none match.
+// Same as last_matching_pattern_from_list() in Git
+func (file *ignoreFile) lastMatchingPattern(path string, isDir bool, ignoreCase bool) *ignorePattern {
+	flags := 0
+	if ignoreCase {
+		flags |= wildmatchCaseFold
+	}
+
+	basename := path[strings.LastIndexByte(path, '/')+1:]
+
+	for i := len(file.patterns) - 1; i >= 0; i-- {
+		p := &file.patterns[i]
+		if p.mustBeDir && !isDir {
+			continue
+		}
+
+		if p.noDir {
+			if wildmatch(p.pattern, basename, flags) {
+				return p
+			}
+			continue
+		}
+
+		// The pattern is relative to the folder of the ignore file
+		if len(path) < len(file.base) {
+			continue
+		}
+		if prefix := path[:len(file.base)]; prefix != file.base && !(ignoreCase && foldCase(prefix) == foldCase(file.base)) {
+			continue
+		}
+
+		if wildmatch(p.pattern, path[len(file.base):], flags|wildmatchPathname) {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// The ignore files of a work tree
+type ignoreRules struct {
+	folders    map[string]*ignoreFile // The .gitignore files by the folder they are in, "" for the root folder
+	excludes   []*ignoreFile          // Files for the whole work tree like $GIT_DIR/info/exclude, in order of increasing precedence
+	ignoreCase bool
+}
+
+// Returns the pattern deciding if path is ignored, or nil if no pattern matches.
+// The pattern is negative if it un-ignores the path.
+// path is "/"-separated and relative to the work tree, isDir tells if it is a folder.
+// Same as last_matching_pattern() in Git: a path inside an ignored folder is always ignored, even if a pattern un-ignores it.
+func (rules *ignoreRules) lastMatchingPattern(path string, isDir bool) (*ignoreFile, *ignorePattern) {
+	for i := 0; i < len(path); i++ {
+		if path[i] != '/' {
+			continue
+		}
+
+		file, p := rules.lastMatchingPatternFromFiles(path[:i], true)
+		if p != nil && !p.negative {
+			return file, p
+		}
+	}
+
+	return rules.lastMatchingPatternFromFiles(path, isDir)
+}
+
+// Same as lastMatchingPattern(), but only tells if path is ignored.
+// ignoredFolders caches whether the folders containing path are ignored, it can be nil.
+func (rules *ignoreRules) isIgnored(path string, isDir bool, ignoredFolders map[string]bool) bool {
+	for i := 0; i < len(path); i++ {
+		if path[i] != '/' {
+			continue
+		}
+
+		folder := path[:i]
+		ignored, ok := ignoredFolders[folder]
+		if !ok {
+			_, p := rules.lastMatchingPatternFromFiles(folder, true)
+			ignored = p != nil && !p.negative
+			if ignoredFolders != nil {
+				ignoredFolders[folder] = ignored
+			}
+		}
+
+		if ignored {
+			return true
+		}
+	}
+
+	_, p := rules.lastMatchingPatternFromFiles(path, isDir)
+	return p != nil && !p.negative
+}
+
+// Checks the .gitignore files of the folders containing path from the innermost to the root, and then the excludes files
+func (rules *ignoreRules) lastMatchingPatternFromFiles(path string, isDir bool) (*ignoreFile, *ignorePattern) {
+	folder := path
+	for {
+		slash := strings.LastIndexByte(folder, '/')
+		if slash == -1 {
+			folder = ""
+		} else {
+			folder = folder[:slash]
+		}
+
+		if file, ok := rules.folders[folder]; ok {
+			if p := file.lastMatchingPattern(path, isDir, rules.ignoreCase); p != nil {
+				return file, p
+			}
+		}
+
+		if folder == "" {
+			break
+		}
+	}
+
+	for i := len(rules.excludes) - 1; i >= 0; i-- {
+		if p := rules.excludes[i].lastMatchingPattern(path, isDir, rules.ignoreCase); p != nil {
+			return rules.excludes[i], p
+		}
+	}
+
+	return nil, nil
+}
+
+// Returns the ignore files for the whole repository, in order of increasing precedence:
+// the global excludes file (core.excludesFile) and $GIT_DIR/info/exclude
+func excludesFilePaths(repo *Repository, config *Config) ([]string, error) {
+	globalExcludesPath, err := globalExcludesFilePath(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	if globalExcludesPath != "" {
+		paths = append(paths, globalExcludesPath)
+	}
+	return append(paths, filepath.Join(repo.CommonDir, "info", "exclude")), nil
+}
+
+// Reads the ignore files for the whole repository returned by excludesFilePaths().
+// Like Git, files that can't be read are skipped (Git warns about them unless they are missing).
+func readExcludesFiles(paths []string, settings *statusSettings) []*ignoreFile {
+	var files []*ignoreFile
+	for _, path := range paths {
+		file, err := readIgnoreFile(path, "", false, settings)
+		if err == nil {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// An ignore pattern matching a path, returned by CheckIgnore()
+type IgnoreMatch struct {
+	Ignored bool   // false if Pattern un-ignores the path, like "!file.txt"
+	Source  string // The ignore file containing Pattern, relative to the work tree if it is inside of it
+	Line    int    // The line number of Pattern in Source, starting at 1
+	Pattern string // The pattern as written in Source, without trailing spaces
+}
+
+// Returns the ignore pattern deciding if path is ignored, or nil if no pattern matches it, like `git check-ignore -v --no-index`.
+// path is either absolute or relative to repo.WorkTree, and does not have to exist.
+// A path ending in a path separator is a folder, otherwise it is a folder only if there is a folder at path.
+// Whether path is tracked is not taken into account.
+func CheckIgnore(repo *Repository, path string) (*IgnoreMatch, error) {
+	if repo.WorkTree == "" {
+		return nil, errors.New("this operation must be run in a work tree")
+	}
+
+	if filepath.IsAbs(path) {
+		rel, err := filepath.Rel(repo.WorkTree, path)
+		if err != nil {
+			return nil, errors.New("path is outside the work tree: " + path)
+		}
+		if strings.HasSuffix(path, string(os.PathSeparator)) || strings.HasSuffix(path, "/") {
+			rel += "/"
+		}
+		path = rel
+	}
+
+	isDir := strings.HasSuffix(filepath.ToSlash(path), "/")
+	path = filepath.ToSlash(filepath.Clean(path))
+	if path == ".." || strings.HasPrefix(path, "../") {
+		return nil, errors.New("path is outside the work tree: " + path)
+	}
+	if path == "." {
+		return nil, nil
+	}
+
+	if !isDir {
+		stat, err := os.Lstat(filepath.Join(repo.WorkTree, filepath.FromSlash(path)))
+		isDir = err == nil && stat.IsDir()
+	}
+
+	config, err := ReadConfig(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := readStatusSettings(config)
+	if err != nil {
+		return nil, err
+	}
+
+	excludesPaths, err := excludesFilePaths(repo, config)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := &ignoreRules{
+		folders:    make(map[string]*ignoreFile),
+		excludes:   readExcludesFiles(excludesPaths, settings),
+		ignoreCase: settings.ignoreCase,
+	}
+
+	// The .gitignore files of all the folders containing path
+	folder := ""
+	for {
+		var base string
+		if folder != "" {
+			base = folder + "/"
+		}
+
+		file, err := readIgnoreFile(filepath.Join(repo.WorkTree, filepath.FromSlash(base), ".gitignore"), base, true, settings)
+		if err == nil {
+			rules.folders[folder] = file
+		}
+
+		slash := strings.IndexByte(path[len(base):], '/')
+		if slash == -1 {
+			break
+		}
+		folder = path[:len(base)+slash]
+	}
+
+	if settings.precomposeUnicode {
+		path = norm.NFC.String(path)
+	}
+
+	file, p := rules.lastMatchingPattern(path, isDir)
+	if p == nil {
+		return nil, nil
+	}
+
+	source := file.path
+	if rel, err := filepath.Rel(repo.WorkTree, source); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		source = rel
+	}
+
+	return &IgnoreMatch{
+		Ignored: !p.negative,
+		Source:  source,
+		Line:    p.line,
+		Pattern: p.text,
+	}, nil
+}
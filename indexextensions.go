@@ -0,0 +1,680 @@
+package gogitstatus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+)
+
+// The extensions found after the entries of a Git index file.
+// Extensions that are not present are nil (or false/empty).
+// See: https://git-scm.com/docs/index-format#_extensions
+type GitIndexExtensions struct {
+	CacheTree         *CacheTree                 // "TREE"
+	ResolveUndo       []ResolveUndoEntry         // "REUC"
+	SplitIndex        *SplitIndexLink            // "link"
+	UntrackedCache    *UntrackedCache            // "UNTR"
+	FSMonitor         *FSMonitorData             // "FSMN"
+	EndOfIndexEntry   *EndOfIndexEntry           // "EOIE"
+	IndexEntryOffsets *IndexEntryOffsetTable     // "IEOT"
+	SparseDirectories bool                       // "sdir", the index may contain directory entries (sparse index)
+	Unknown           []UnknownGitIndexExtension // Extensions we don't know about, in the order they appear
+}
+
+// The cached tree objects of the index ("TREE" extension)
+type CacheTree struct {
+	Path       string // Path component relative to the parent tree, empty for the root tree
+	EntryCount int    // The amount of index entries covered by this tree, -1 if it is invalidated
+	Hash       []byte // nil if the tree is invalidated
+	Subtrees   []*CacheTree
+}
+
+// The stages of a path before a merge conflict was resolved ("REUC" extension)
+type ResolveUndoEntry struct {
+	Path   string
+	Modes  [3]uint32 // The modes of stage 1, 2 and 3, 0 if the path was missing in that stage
+	Hashes [3][]byte // nil if the path was missing in that stage
+}
+
+// Points to the shared index of a split index ("link" extension)
+type SplitIndexLink struct {
+	SharedIndexHash []byte     // The shared index is found at $GIT_DIR/sharedindex.<hash>
+	DeleteBitmap    EWAHBitmap // Positions of the entries in the shared index that are deleted
+	ReplaceBitmap   EWAHBitmap // Positions of the entries in the shared index that are replaced by entries in this index
+}
+
+// Stat data as stored in the untracked cache
+type IndexStatData struct {
+	MetadataChangedTimeSeconds     uint32 // ctime
+	MetadataChangedTimeNanoSeconds uint32 // ctime
+	ModifiedTimeSeconds            uint32
+	ModifiedTimeNanoSeconds        uint32
+	Device                         uint32 // dev
+	Inode                          uint32 // ino
+	UserID                         uint32 // uid
+	GroupID                        uint32 // gid
+	FileSize                       uint32
+}
+
+// The untracked cache ("UNTR" extension)
+type UntrackedCache struct {
+	Environment      []string // Describes the environment where the cache can be used
+	InfoExcludeStat  IndexStatData
+	ExcludesFileStat IndexStatData
+	DirFlags         uint32
+	InfoExcludeHash  []byte // Hash of $GIT_DIR/info/exclude, all zeroes if it doesn't exist
+	ExcludesFileHash []byte // Hash of core.excludesFile, all zeroes if it doesn't exist
+	ExcludePerDir    string // Usually ".gitignore"
+	Root             *UntrackedCacheDirectory
+}
+
+type UntrackedCacheDirectory struct {
+	Name           string   // Relative to the parent directory, empty for the root
+	Untracked      []string // Untracked file and directory names inside this directory
+	Subdirectories []*UntrackedCacheDirectory
+	Valid          bool          // Stat is only set if Valid is true
+	CheckOnly      bool          // Only checks if the directory has any untracked files
+	Stat           IndexStatData // Stat data of the directory
+	ExcludeHash    []byte        // Hash of the .gitignore in this directory, nil if unknown
+}
+
+// The file system monitor state ("FSMN" extension)
+type FSMonitorData struct {
+	Version   uint32
+	Timestamp uint64     // Version 1 only, nanoseconds since the epoch
+	Token     string     // Version 2 only, opaque token from the file system monitor
+	Dirty     EWAHBitmap // Positions of the index entries that are not known to be unchanged
+}
+
+// Where the index entries end ("EOIE" extension)
+type EndOfIndexEntry struct {
+	Offset uint32 // Offset from the beginning of the file to the end of the index entries
+	Hash   []byte // Hash over the signatures and sizes of the extensions before this one
+}
+
+// Blocks of index entries that can be read in parallel ("IEOT" extension)
+type IndexEntryOffsetTable struct {
+	Version uint32
+	Blocks  []IndexEntryOffsetBlock
+}
+
+type IndexEntryOffsetBlock struct {
+	Offset uint32 // Offset from the beginning of the file to the first entry of the block
+	Count  uint32 // The amount of entries in the block
+}
+
+type UnknownGitIndexExtension struct {
+	Signature string // 4 bytes. If the first byte is not an uppercase letter, Git would refuse to read the index.
+	Data      []byte
+}
+
+// Returns the data of the first extension with the given signature, or nil if it is missing.
+// Faster than parseGitIndexExtensions() since the other extensions are skipped over without being parsed.
+func findGitIndexExtension(data []byte, offset int, hashAlgorithm HashAlgorithm, signature string) []byte {
+	end := len(data) - hashAlgorithm.Size()
+	for offset+8 <= end {
+		size := binary.BigEndian.Uint32(data[offset+4 : offset+8])
+		if uint64(size) > uint64(end-offset-8) {
+			return nil
+		}
+
+		if string(data[offset:offset+4]) == signature {
+			return data[offset+8 : offset+8+int(size)]
+		}
+		offset += 8 + int(size)
+	}
+
+	return nil
+}
+
+// Parses the extensions starting at offset, up until the trailing checksum.
+// https://github.com/git/git/blob/ef8ce8f3d4344fd3af049c17eeba5cd20d98b69f/read-cache.c
+func parseGitIndexExtensions(data []byte, offset int, hashAlgorithm HashAlgorithm) (GitIndexExtensions, error) {
+	var extensions GitIndexExtensions
+
+	end := len(data) - hashAlgorithm.Size()
+	if end < offset {
+		return extensions, &IndexCorruptError{Offset: offset, EntryIndex: -1, Reason: "invalid size, no room for the trailing checksum"}
+	}
+
+	for offset+8 <= end {
+		signature := string(data[offset : offset+4])
+		size := binary.BigEndian.Uint32(data[offset+4 : offset+8])
+
+		if uint64(size) > uint64(end-offset-8) {
+			return extensions, &IndexCorruptError{Offset: offset, EntryIndex: -1, Reason: "invalid size, extension \"" + signature + "\" of size " + strconv.FormatUint(uint64(size), 10) + " goes past the end of the index"}
+		}
+		offset += 8
+
+		extensionOffset := offset
+		extensionData := data[offset : offset+int(size)]
+		offset += int(size)
+
+		reader := bytes.NewReader(extensionData)
+
+		var err error
+		known := true
+		switch signature {
+		case "TREE":
+			extensions.CacheTree, err = parseCacheTreeExtension(reader, hashAlgorithm)
+		case "REUC":
+			extensions.ResolveUndo, err = parseResolveUndoExtension(reader, hashAlgorithm)
+		case "link":
+			extensions.SplitIndex, err = parseSplitIndexLinkExtension(reader, hashAlgorithm)
+		case "UNTR":
+			extensions.UntrackedCache, err = parseUntrackedCacheExtension(reader, hashAlgorithm)
+		case "FSMN":
+			extensions.FSMonitor, err = parseFSMonitorExtension(reader)
+		case "EOIE":
+			extensions.EndOfIndexEntry, err = parseEndOfIndexEntryExtension(reader, hashAlgorithm)
+		case "IEOT":
+			extensions.IndexEntryOffsets, err = parseIndexEntryOffsetTableExtension(reader)
+		case "sdir":
+			extensions.SparseDirectories = true
+		default:
+			known = false
+			extensions.Unknown = append(extensions.Unknown, UnknownGitIndexExtension{
+				Signature: signature,
+				Data:      bytes.Clone(extensionData),
+			})
+		}
+
+		// The reader is left where the problem was found
+		if err != nil {
+			return extensions, &IndexCorruptError{Offset: extensionOffset + len(extensionData) - reader.Len(), EntryIndex: -1, Reason: "invalid \"" + signature + "\" extension: " + err.Error()}
+		}
+
+		if known && reader.Len() != 0 {
+			return extensions, &IndexCorruptError{Offset: extensionOffset + len(extensionData) - reader.Len(), EntryIndex: -1, Reason: "invalid \"" + signature + "\" extension: " + strconv.Itoa(reader.Len()) + " unexpected bytes at the end"}
+		}
+	}
+
+	return extensions, nil
+}
+
+func readNullTerminatedString(reader *bytes.Reader) (string, error) {
+	var ret []byte
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return "", errors.New("invalid size, missing null byte at the end of a string")
+		}
+
+		if b == 0 {
+			return string(ret), nil
+		}
+
+		ret = append(ret, b)
+	}
+}
+
+func readUint32(reader *bytes.Reader) (uint32, error) {
+	fourBytes := make([]byte, 4)
+	if _, err := io.ReadFull(reader, fourBytes); err != nil {
+		return 0, errors.New("invalid size, unable to read 32-bit integer")
+	}
+	return binary.BigEndian.Uint32(fourBytes), nil
+}
+
+func readHash(reader *bytes.Reader, hashAlgorithm HashAlgorithm) ([]byte, error) {
+	hash := make([]byte, hashAlgorithm.Size())
+	if _, err := io.ReadFull(reader, hash); err != nil {
+		return nil, errors.New("invalid size, unable to read " + strconv.Itoa(hashAlgorithm.Size()) + "-byte " + hashAlgorithm.String() + " hash")
+	}
+	return hash, nil
+}
+
+func readIndexStatData(reader *bytes.Reader) (IndexStatData, error) {
+	var stat IndexStatData
+	fields := []*uint32{
+		&stat.MetadataChangedTimeSeconds,
+		&stat.MetadataChangedTimeNanoSeconds,
+		&stat.ModifiedTimeSeconds,
+		&stat.ModifiedTimeNanoSeconds,
+		&stat.Device,
+		&stat.Inode,
+		&stat.UserID,
+		&stat.GroupID,
+		&stat.FileSize,
+	}
+
+	for _, field := range fields {
+		value, err := readUint32(reader)
+		if err != nil {
+			return stat, errors.New("invalid size, unable to read stat data")
+		}
+		*field = value
+	}
+
+	return stat, nil
+}
+
+// https://github.com/git/git/blob/ef8ce8f3d4344fd3af049c17eeba5cd20d98b69f/cache-tree.c
+func parseCacheTreeExtension(reader *bytes.Reader, hashAlgorithm HashAlgorithm) (*CacheTree, error) {
+	readNode := func() (*CacheTree, int, error) {
+		path, err := readNullTerminatedString(reader)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		var line []byte
+		for {
+			b, err := reader.ReadByte()
+			if err != nil {
+				return nil, 0, errors.New("invalid size, missing newline after the entry count")
+			}
+			if b == '\n' {
+				break
+			}
+			line = append(line, b)
+		}
+
+		entryCountText, subtreeCountText, found := bytes.Cut(line, []byte{' '})
+		if !found {
+			return nil, 0, errors.New("missing space between entry count and subtree count")
+		}
+
+		entryCount, err := strconv.Atoi(string(entryCountText))
+		if err != nil {
+			return nil, 0, errors.New("invalid entry count: " + err.Error())
+		}
+
+		subtreeCount, err := strconv.Atoi(string(subtreeCountText))
+		if err != nil || subtreeCount < 0 {
+			return nil, 0, errors.New("invalid subtree count: " + string(subtreeCountText))
+		}
+
+		tree := &CacheTree{Path: path, EntryCount: entryCount}
+		if entryCount >= 0 {
+			tree.Hash, err = readHash(reader, hashAlgorithm)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+
+		return tree, subtreeCount, nil
+	}
+
+	root, subtreeCount, err := readNode()
+	if err != nil {
+		return nil, err
+	}
+
+	// The subtrees follow their parent depth-first.
+	// We use a stack instead of recursion, since the depth is determined by the file.
+	type pendingTree struct {
+		tree              *CacheTree
+		remainingSubtrees int
+	}
+	stack := []pendingTree{{root, subtreeCount}}
+
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		if top.remainingSubtrees == 0 {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		top.remainingSubtrees--
+
+		subtree, subtreeCount, err := readNode()
+		if err != nil {
+			return nil, err
+		}
+
+		top.tree.Subtrees = append(top.tree.Subtrees, subtree)
+		stack = append(stack, pendingTree{subtree, subtreeCount})
+	}
+
+	return root, nil
+}
+
+// https://github.com/git/git/blob/ef8ce8f3d4344fd3af049c17eeba5cd20d98b69f/resolve-undo.c
+func parseResolveUndoExtension(reader *bytes.Reader, hashAlgorithm HashAlgorithm) ([]ResolveUndoEntry, error) {
+	var entries []ResolveUndoEntry
+
+	for reader.Len() > 0 {
+		var entry ResolveUndoEntry
+
+		var err error
+		entry.Path, err = readNullTerminatedString(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range entry.Modes {
+			modeText, err := readNullTerminatedString(reader)
+			if err != nil {
+				return nil, err
+			}
+
+			mode, err := strconv.ParseUint(modeText, 8, 32)
+			if err != nil {
+				return nil, errors.New("invalid mode: " + modeText)
+			}
+			entry.Modes[i] = uint32(mode)
+		}
+
+		for i, mode := range entry.Modes {
+			if mode == 0 {
+				continue
+			}
+
+			entry.Hashes[i], err = readHash(reader, hashAlgorithm)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// https://github.com/git/git/blob/ef8ce8f3d4344fd3af049c17eeba5cd20d98b69f/split-index.c
+func parseSplitIndexLinkExtension(reader *bytes.Reader, hashAlgorithm HashAlgorithm) (*SplitIndexLink, error) {
+	var link SplitIndexLink
+
+	var err error
+	link.SharedIndexHash, err = readHash(reader, hashAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	// The bitmaps are left out when there is nothing to delete or replace
+	if reader.Len() == 0 {
+		return &link, nil
+	}
+
+	link.DeleteBitmap, err = readEWAHBitmap(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	link.ReplaceBitmap, err = readEWAHBitmap(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return &link, nil
+}
+
+// https://github.com/git/git/blob/ef8ce8f3d4344fd3af049c17eeba5cd20d98b69f/dir.c
+func parseUntrackedCacheExtension(reader *bytes.Reader, hashAlgorithm HashAlgorithm) (*UntrackedCache, error) {
+	var cache UntrackedCache
+
+	environmentLength, err := readIndexVarint(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if environmentLength > uint64(reader.Len()) {
+		return nil, errors.New("invalid size, environment length " + strconv.FormatUint(environmentLength, 10) + " is larger than the remaining data")
+	}
+
+	environment := make([]byte, environmentLength)
+	if _, err := io.ReadFull(reader, environment); err != nil {
+		return nil, err
+	}
+
+	// A sequence of null-terminated strings
+	for len(environment) > 0 {
+		before, after, _ := bytes.Cut(environment, []byte{0})
+		cache.Environment = append(cache.Environment, string(before))
+		environment = after
+	}
+
+	cache.InfoExcludeStat, err = readIndexStatData(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.ExcludesFileStat, err = readIndexStatData(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.DirFlags, err = readUint32(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.InfoExcludeHash, err = readHash(reader, hashAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.ExcludesFileHash, err = readHash(reader, hashAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.ExcludePerDir, err = readNullTerminatedString(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	numDirectories, err := readIndexVarint(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	// No root directory yet, like right after `git update-index --untracked-cache`
+	if numDirectories == 0 {
+		return &cache, nil
+	}
+
+	readDirectory := func() (*UntrackedCacheDirectory, uint64, error) {
+		numUntracked, err := readIndexVarint(reader)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		numSubdirectories, err := readIndexVarint(reader)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		var directory UntrackedCacheDirectory
+		directory.Name, err = readNullTerminatedString(reader)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		for i := uint64(0); i < numUntracked; i++ {
+			name, err := readNullTerminatedString(reader)
+			if err != nil {
+				return nil, 0, err
+			}
+			directory.Untracked = append(directory.Untracked, name)
+		}
+
+		return &directory, numSubdirectories, nil
+	}
+
+	// The directories are stored depth-first, the bitmaps below refer to them in that order
+	directories := make([]*UntrackedCacheDirectory, 0)
+
+	root, numSubdirectories, err := readDirectory()
+	if err != nil {
+		return nil, err
+	}
+	directories = append(directories, root)
+
+	type pendingDirectory struct {
+		directory               *UntrackedCacheDirectory
+		remainingSubdirectories uint64
+	}
+	stack := []pendingDirectory{{root, numSubdirectories}}
+
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		if top.remainingSubdirectories == 0 {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		top.remainingSubdirectories--
+
+		subdirectory, numSubdirectories, err := readDirectory()
+		if err != nil {
+			return nil, err
+		}
+
+		top.directory.Subdirectories = append(top.directory.Subdirectories, subdirectory)
+		directories = append(directories, subdirectory)
+		stack = append(stack, pendingDirectory{subdirectory, numSubdirectories})
+	}
+
+	if uint64(len(directories)) != numDirectories {
+		return nil, errors.New("expected " + strconv.FormatUint(numDirectories, 10) + " directories, but found " + strconv.Itoa(len(directories)))
+	}
+
+	valid, err := readEWAHBitmap(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	checkOnly, err := readEWAHBitmap(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	hashValid, err := readEWAHBitmap(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	checkOnly.ForEachSetBit(func(position int) bool {
+		if position >= len(directories) {
+			return false
+		}
+		directories[position].CheckOnly = true
+		return true
+	})
+
+	valid.ForEachSetBit(func(position int) bool {
+		if position >= len(directories) {
+			return false
+		}
+
+		directories[position].Stat, err = readIndexStatData(reader)
+		if err != nil {
+			return false
+		}
+		directories[position].Valid = true
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	hashValid.ForEachSetBit(func(position int) bool {
+		if position >= len(directories) {
+			return false
+		}
+
+		directories[position].ExcludeHash, err = readHash(reader, hashAlgorithm)
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := reader.ReadByte()
+	if err != nil || b != 0 {
+		return nil, errors.New("missing null byte at the end")
+	}
+
+	cache.Root = root
+	return &cache, nil
+}
+
+// https://github.com/git/git/blob/ef8ce8f3d4344fd3af049c17eeba5cd20d98b69f/fsmonitor.c
+func parseFSMonitorExtension(reader *bytes.Reader) (*FSMonitorData, error) {
+	var fsmonitor FSMonitorData
+
+	var err error
+	fsmonitor.Version, err = readUint32(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	switch fsmonitor.Version {
+	case 1:
+		eightBytes := make([]byte, 8)
+		if _, err := io.ReadFull(reader, eightBytes); err != nil {
+			return nil, errors.New("invalid size, unable to read 64-bit timestamp")
+		}
+		fsmonitor.Timestamp = binary.BigEndian.Uint64(eightBytes)
+	case 2:
+		fsmonitor.Token, err = readNullTerminatedString(reader)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("unsupported version: " + strconv.FormatUint(uint64(fsmonitor.Version), 10))
+	}
+
+	bitmapSize, err := readUint32(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if uint64(bitmapSize) != uint64(reader.Len()) {
+		return nil, errors.New("invalid size, bitmap size " + strconv.FormatUint(uint64(bitmapSize), 10) + " does not match the remaining " + strconv.Itoa(reader.Len()) + " bytes")
+	}
+
+	fsmonitor.Dirty, err = readEWAHBitmap(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fsmonitor, nil
+}
+
+// https://github.com/git/git/blob/ef8ce8f3d4344fd3af049c17eeba5cd20d98b69f/read-cache.c
+func parseEndOfIndexEntryExtension(reader *bytes.Reader, hashAlgorithm HashAlgorithm) (*EndOfIndexEntry, error) {
+	var eoie EndOfIndexEntry
+
+	var err error
+	eoie.Offset, err = readUint32(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	eoie.Hash, err = readHash(reader, hashAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &eoie, nil
+}
+
+// https://github.com/git/git/blob/ef8ce8f3d4344fd3af049c17eeba5cd20d98b69f/read-cache.c
+func parseIndexEntryOffsetTableExtension(reader *bytes.Reader) (*IndexEntryOffsetTable, error) {
+	var table IndexEntryOffsetTable
+
+	var err error
+	table.Version, err = readUint32(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if table.Version != 1 {
+		return nil, errors.New("unsupported version: " + strconv.FormatUint(uint64(table.Version), 10))
+	}
+
+	if reader.Len()%8 != 0 {
+		return nil, errors.New("invalid size, " + strconv.Itoa(reader.Len()) + " is not a multiple of 8")
+	}
+
+	table.Blocks = make([]IndexEntryOffsetBlock, reader.Len()/8)
+	for i := range table.Blocks {
+		table.Blocks[i].Offset, _ = readUint32(reader)
+		table.Blocks[i].Count, _ = readUint32(reader)
+	}
+
+	return &table, nil
+}
@@ -0,0 +1,64 @@
+package gogitstatus
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"hash"
+)
+
+// The hash algorithm used for object IDs in a repository.
+// The zero value is SHA-1, the default for Git repositories.
+type HashAlgorithm uint8
+
+const (
+	SHA1   HashAlgorithm = 0
+	SHA256 HashAlgorithm = 1
+)
+
+// Returns the size of a hash in bytes, 20 for SHA-1 and 32 for SHA-256
+func (h HashAlgorithm) Size() int {
+	if h == SHA256 {
+		return sha256.Size
+	}
+	return sha1.Size
+}
+
+// Returns the name used for extensions.objectFormat in the Git config, "sha1" or "sha256"
+func (h HashAlgorithm) String() string {
+	if h == SHA256 {
+		return "sha256"
+	}
+	return "sha1"
+}
+
+func (h HashAlgorithm) new() hash.Hash {
+	if h == SHA256 {
+		return sha256.New()
+	}
+	return sha1.New()
+}
+
+// Returns the hash algorithm of a repository, read from extensions.objectFormat in the config file inside gitDirPath (usually a ".git" folder).
+// A missing config file or objectFormat value means SHA-1.
+// See: https://git-scm.com/docs/hash-function-transition
+func ReadHashAlgorithm(gitDirPath string) (HashAlgorithm, error) {
+	config, err := readRepositoryConfig(gitDirPath)
+	if err != nil {
+		return SHA1, err
+	}
+
+	value, found := config.Get("extensions.objectformat")
+	if !found {
+		return SHA1, nil
+	}
+
+	switch value {
+	case "sha1":
+		return SHA1, nil
+	case "sha256":
+		return SHA256, nil
+	default:
+		return SHA1, errors.New("unknown repository object format: " + value)
+	}
+}
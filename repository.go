@@ -0,0 +1,243 @@
+package gogitstatus
+
+import (
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The locations that make up a Git repository
+type Repository struct {
+	WorkTree  string // The root folder of the checked out files
+	GitDir    string // The ".git" folder, or the folder a ".git" file points to
+	CommonDir string // The folder with the state shared between linked worktrees (config, objects, refs), same as GitDir outside of a linked worktree
+	IndexFile string // Usually "index" inside GitDir
+}
+
+// Returns the repository with workTree as its root folder.
+// A ".git" file with a "gitdir: " pointer is followed, like in linked worktrees (`git worktree add`) and submodules.
+func OpenRepository(workTree string) (*Repository, error) {
+	gitDir, err := resolveDotGit(myJoin(workTree, ".git"))
+	if err != nil {
+		return nil, err
+	}
+
+	return openRepositoryWithGitDir(workTree, gitDir)
+}
+
+func openRepositoryWithGitDir(workTree, gitDir string) (*Repository, error) {
+	commonDir, err := readCommonDir(gitDir)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateGitDirectory(gitDir, commonDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Repository{
+		WorkTree:  workTree,
+		GitDir:    gitDir,
+		CommonDir: commonDir,
+		IndexFile: myJoin(gitDir, "index"),
+	}, nil
+}
+
+// Returns the Git directory for a ".git" folder or file.
+// https://github.com/git/git/blob/ef8ce8f3d4344fd3af049c17eeba5cd20d98b69f/setup.c
+func resolveDotGit(dotGitPath string) (string, error) {
+	stat, err := os.Stat(dotGitPath)
+	if err != nil {
+		return "", errors.New("not a Git repository")
+	}
+
+	if stat.IsDir() {
+		return dotGitPath, nil
+	}
+
+	if !stat.Mode().IsRegular() {
+		return "", errors.New("not a Git repository")
+	}
+
+	data, err := os.ReadFile(dotGitPath)
+	if err != nil {
+		return "", err
+	}
+
+	gitDir, found := strings.CutPrefix(string(data), "gitdir: ")
+	gitDir = strings.TrimRight(gitDir, "\r\n")
+	if !found || gitDir == "" {
+		return "", errors.New("invalid gitfile format: " + dotGitPath)
+	}
+
+	// Relative to the folder containing the ".git" file
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(filepath.Dir(dotGitPath), gitDir)
+	}
+
+	stat, err = os.Stat(gitDir)
+	if err != nil || !stat.IsDir() {
+		return "", errors.New("not a Git repository: " + gitDir)
+	}
+
+	return gitDir, nil
+}
+
+// Returns the folder pointed to by the "commondir" file of a linked worktree, or gitDir if there is none.
+func readCommonDir(gitDir string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(gitDir, "commondir"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return gitDir, nil
+		}
+		return "", err
+	}
+
+	commonDir := strings.TrimRight(string(data), "\r\n")
+	if commonDir == "" {
+		return gitDir, nil
+	}
+
+	// Relative to gitDir
+	if !filepath.IsAbs(commonDir) {
+		commonDir = filepath.Join(gitDir, commonDir)
+	}
+
+	return commonDir, nil
+}
+
+// Returned when a folder is rejected as a Git directory, e.g. an empty ".git" folder
+type InvalidRepositoryError struct {
+	Path   string // The rejected Git directory
+	Reason string // Why it was rejected
+}
+
+func (e *InvalidRepositoryError) Error() string {
+	return "not a Git repository: " + e.Path + ": " + e.Reason
+}
+
+// The repository extensions we understand with core.repositoryformatversion = 1, in lowercase.
+// Git refuses to touch a repository with any other extension, so we do the same.
+var knownRepositoryExtensions = map[string]bool{
+	"noop":                true,
+	"noop-v1":             true,
+	"preciousobjects":     true,
+	"partialclone":        true,
+	"worktreeconfig":      true,
+	"objectformat":        true,
+	"compatobjectformat":  true,
+	"refstorage":          true,
+	"submodulepathconfig": true,
+}
+
+// Returns an *InvalidRepositoryError if gitDir is not a Git directory, using the same checks as Git.
+// The "objects" and "refs" folders and the config file are looked for in commonDir, which is gitDir outside of a linked worktree.
+// https://github.com/git/git/blob/ef8ce8f3d4344fd3af049c17eeba5cd20d98b69f/setup.c
+func validateGitDirectory(gitDir, commonDir string) error {
+	err := checkGitDirectoryLayout(gitDir, commonDir)
+	if err != nil {
+		return err
+	}
+
+	return checkRepositoryFormat(gitDir, commonDir)
+}
+
+// Same as is_git_directory() in Git
+func checkGitDirectoryLayout(gitDir, commonDir string) error {
+	for _, dir := range []string{"objects", "refs"} {
+		stat, err := os.Stat(filepath.Join(commonDir, dir))
+		if err != nil || !stat.IsDir() {
+			return &InvalidRepositoryError{Path: gitDir, Reason: "missing \"" + dir + "\" folder"}
+		}
+	}
+
+	if !isValidHead(filepath.Join(gitDir, "HEAD")) {
+		return &InvalidRepositoryError{Path: gitDir, Reason: "missing or invalid HEAD"}
+	}
+
+	return nil
+}
+
+// Rejects repositories with a core.repositoryformatversion or extensions we don't understand
+func checkRepositoryFormat(gitDir, commonDir string) error {
+	invalid := func(reason string) error {
+		return &InvalidRepositoryError{Path: gitDir, Reason: reason}
+	}
+
+	config, err := readRepositoryConfig(commonDir)
+	if err != nil {
+		return invalid("unable to read config: " + err.Error())
+	}
+
+	formatVersion := 0
+	if value, found := config.Get("core.repositoryformatversion"); found {
+		formatVersion, err = strconv.Atoi(value)
+		if err != nil || formatVersion < 0 {
+			return invalid("invalid core.repositoryformatversion")
+		}
+	}
+
+	if formatVersion > 1 {
+		return invalid("unsupported core.repositoryformatversion " + strconv.Itoa(formatVersion))
+	}
+
+	// Extensions are ignored in version 0
+	if formatVersion == 1 {
+		for _, e := range config.entries {
+			extension, found := strings.CutPrefix(e.key, "extensions.")
+			if found && !knownRepositoryExtensions[extension] {
+				return invalid("unknown repository extension: " + extension)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Returns true if the HEAD file at path is a symbolic ref to something in "refs/", or a detached HEAD with an object id.
+// HEAD may also be a symbolic link starting with "refs/", which old versions of Git created.
+func isValidHead(path string) bool {
+	stat, err := os.Lstat(path)
+	if err != nil {
+		return false
+	}
+
+	if stat.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		return err == nil && strings.HasPrefix(target, "refs/")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	// Git only reads the first 255 bytes
+	data, err := io.ReadAll(io.LimitReader(file, 255))
+	if err != nil {
+		return false
+	}
+
+	if ref, ok := strings.CutPrefix(string(data), "ref:"); ok {
+		if strings.HasPrefix(strings.TrimLeft(ref, " \t\r\n"), "refs/") {
+			return true
+		}
+	}
+
+	for _, algorithm := range []HashAlgorithm{SHA256, SHA1} {
+		hexSize := algorithm.Size() * 2
+		if len(data) >= hexSize {
+			if _, err := hex.DecodeString(string(data[:hexSize])); err == nil {
+				return true
+			}
+		}
+	}
+
+	return false
+}
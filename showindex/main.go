@@ -4,36 +4,65 @@ import (
 	"context"
 	"encoding/hex"
 	"fmt"
-	"io/fs"
 	"os"
-	"strconv"
-	"strings"
 
 	"github.com/kivattt/gogitstatus"
 )
 
-func threeLeadingZeroes(s string) string {
-	return strings.Repeat("0", 3-len(s)) + s
-}
-
 func main() {
 	args := os.Args
 
-	if len(args) < 2 {
+	if len(args) > 1 && (args[1] == "-h" || args[1] == "--help") {
 		fmt.Println("Usage: showindex [git index file]")
+		fmt.Println("The repository is found from the current folder like Git does, the index file defaults to the one of the repository")
 		os.Exit(0)
 	}
 
-	path := os.Args[1]
+	// Like `git ls-files`, the config and the shared index of a split index are always in the Git directory,
+	// even when the index file is somewhere else (like with GIT_INDEX_FILE)
+	repo, err := gogitstatus.DiscoverRepository(".", gogitstatus.DiscoverOptions{UseEnvironment: true})
+	if err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+
+	path := repo.IndexFile
+	if len(args) > 1 {
+		path = args[1]
+	}
+
+	// The config tells us if it's a SHA-256 repository
+	hashAlgorithm, err := gogitstatus.ReadHashAlgorithm(repo.CommonDir)
+	if err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
 
 	ctx := context.WithoutCancel(context.Background())
-	entries, err := gogitstatus.ParseGitIndex(ctx, path)
+	index, err := gogitstatus.ParseGitIndexFull(ctx, path, hashAlgorithm)
 	if err != nil {
 		fmt.Println("error:", err)
 		os.Exit(1)
 	}
 
-	for path, e := range entries {
-		fmt.Println(strconv.FormatInt(int64(e.Mode&gogitstatus.OBJECT_TYPE_MASK>>12), 8)+"0"+threeLeadingZeroes(strconv.FormatInt(int64(e.Mode&uint32(fs.ModePerm)), 8)), hex.EncodeToString(e.Hash[:]), path)
+	// With core.splitIndex=true, most of the entries are in a shared index file in the Git directory
+	index, err = gogitstatus.MergeSplitIndex(ctx, index, repo.GitDir)
+	if err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+
+	// Same output as `git ls-files --stage --debug`,
+	// except for flags that only exist in memory in Git, like CE_UPDATE_IN_BASE for entries replaced in a split index
+	for _, e := range index.Entries {
+		// Git prints its in-memory flags, which has the extended flags in the upper 16 bits and no path name length
+		flags := uint32(e.Flags&^0xfff) | uint32(e.ExtendedFlags)<<16
+
+		fmt.Printf("%06o %s %d\t%s\n", e.Mode, hex.EncodeToString(e.Hash), e.Stage, e.Path)
+		fmt.Printf("  ctime: %d:%d\n", e.MetadataChangedTimeSeconds, e.MetadataChangedTimeNanoSeconds)
+		fmt.Printf("  mtime: %d:%d\n", e.ModifiedTimeSeconds, e.ModifiedTimeNanoSeconds)
+		fmt.Printf("  dev: %d\tino: %d\n", e.Device, e.Inode)
+		fmt.Printf("  uid: %d\tgid: %d\n", e.UserID, e.GroupID)
+		fmt.Printf("  size: %d\tflags: %x\n", e.FileSize, flags)
 	}
 }
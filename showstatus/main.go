@@ -25,6 +25,17 @@ func usage(programName string) {
 	fmt.Println("\t--timeout=milliseconds")
 }
 
+// Same text as `git status`
+var conflictTypeToGitStatusString = map[gogitstatus.ConflictType]string{
+	gogitstatus.BOTH_DELETED:    "both deleted:   ",
+	gogitstatus.ADDED_BY_US:     "added by us:    ",
+	gogitstatus.DELETED_BY_THEM: "deleted by them:",
+	gogitstatus.ADDED_BY_THEM:   "added by them:  ",
+	gogitstatus.DELETED_BY_US:   "deleted by us:  ",
+	gogitstatus.BOTH_ADDED:      "both added:     ",
+	gogitstatus.BOTH_MODIFIED:   "both modified:  ",
+}
+
 func main() {
 	//defer profile.Start(profile.CPUProfile).Stop()
 
@@ -111,17 +122,42 @@ func main() {
 		}
 	}
 
+	unmerged := make(map[string]gogitstatus.ChangedFile)
 	unstaged := make(map[string]gogitstatus.ChangedFile)
 	untracked := make(map[string]gogitstatus.ChangedFile)
 
 	for k, e := range paths {
-		if e.Untracked {
+		if e.Conflict != 0 {
+			unmerged[k] = e
+		} else if e.Untracked {
 			untracked[k] = e
 		} else {
 			unstaged[k] = e
 		}
 	}
 
+	if len(unmerged) > 0 {
+		fmt.Println("Unmerged paths:")
+	}
+
+	unmergedKeysSorted := make([]string, 0, len(unmerged))
+	for key := range unmerged {
+		unmergedKeysSorted = append(unmergedKeysSorted, key)
+	}
+	sort.Strings(unmergedKeysSorted)
+	for _, key := range unmergedKeysSorted {
+		conflictStr := conflictTypeToGitStatusString[unmerged[key].Conflict]
+		if verbose {
+			conflictStr = gogitstatus.ConflictTypeToString(unmerged[key].Conflict)
+		}
+
+		if useColor {
+			fmt.Println("        \x1b[0;31m" + conflictStr + " " + key + "\x1b[0m")
+		} else {
+			fmt.Println("        " + conflictStr + " " + key)
+		}
+	}
+
 	if len(unstaged) > 0 {
 		fmt.Println("Changes not staged for commit:")
 	}
@@ -138,6 +174,8 @@ func main() {
 		whatChangedStr := ""
 		if elem.WhatChanged&gogitstatus.DELETED != 0 {
 			whatChangedStr = "deleted:   "
+		} else if elem.WhatChanged&gogitstatus.INTENT_TO_ADD != 0 {
+			whatChangedStr = "new file:  "
 		} else if elem.WhatChanged&gogitstatus.DATA_CHANGED != 0 || elem.WhatChanged&gogitstatus.MODE_CHANGED != 0 {
 			whatChangedStr = "modified:  "
 		} else if elem.WhatChanged&gogitstatus.TYPE_CHANGED != 0 {
@@ -0,0 +1,164 @@
+package gogitstatus
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// The paths included in a sparse checkout, read from $GIT_DIR/info/sparse-checkout
+// See: https://git-scm.com/docs/git-sparse-checkout
+type sparseCheckout struct {
+	// Cone mode, where the patterns only name directories
+	cone          bool
+	recursiveDirs map[string]bool // Everything inside these directories is included
+	parentDirs    map[string]bool // Only the files directly inside these directories are included
+
+	// Non-cone mode, the patterns work like a .gitignore file where a match means the path is included
+	patterns *ignoreFile
+}
+
+// Returns nil if sparse checkout is not enabled with core.sparseCheckout in config.
+// The patterns are read from gitDirPath, which is the worktree's own folder in a linked worktree.
+func readSparseCheckout(gitDirPath string, config *Config) (*sparseCheckout, error) {
+	enabled, _, err := config.GetBool("core.sparsecheckout")
+	if err != nil || !enabled {
+		return nil, err
+	}
+
+	sparseCheckoutPath := filepath.Join(gitDirPath, "info", "sparse-checkout")
+	data, err := os.ReadFile(sparseCheckoutPath)
+	if err != nil {
+		// Git warns about this, and checks out everything
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	cone, _, err := config.GetBool("core.sparsecheckoutcone")
+	if err != nil {
+		return nil, err
+	}
+
+	if cone {
+		lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+		if sparse, ok := parseConeModeSparseCheckout(lines); ok {
+			return sparse, nil
+		}
+		// Like Git, we fall back to non-cone mode when the patterns are not in the cone mode format
+	}
+
+	return &sparseCheckout{patterns: parseIgnoreFile(data, sparseCheckoutPath, "", &statusSettings{})}, nil
+}
+
+// Returns false if the lines are not in the cone mode format, which looks like this:
+//
+//	/*
+//	!/*/
+//	/parent/
+//	!/parent/*/
+//	/parent/recursive/
+//
+// https://github.com/git/git/blob/ef8ce8f3d4344fd3af049c17eeba5cd20d98b69f/dir.c
+func parseConeModeSparseCheckout(lines []string) (*sparseCheckout, bool) {
+	sparse := &sparseCheckout{
+		cone:          true,
+		recursiveDirs: make(map[string]bool),
+		parentDirs:    make(map[string]bool),
+	}
+
+	seenRoot := false
+	for _, line := range lines {
+		line = strings.TrimRight(line, " ")
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		if line == "/*" || line == "!/*/" {
+			seenRoot = true
+			continue
+		}
+
+		if !seenRoot || !strings.HasSuffix(line, "/") {
+			return nil, false
+		}
+
+		if strings.HasPrefix(line, "!/") && strings.HasSuffix(line, "/*/") {
+			dir, ok := unescapeSparseCheckoutPattern(line[len("!/") : len(line)-len("/*/")])
+			if !ok {
+				return nil, false
+			}
+			// The "/parent/" line before this one is not recursive after all
+			delete(sparse.recursiveDirs, dir)
+			sparse.parentDirs[dir] = true
+		} else if strings.HasPrefix(line, "/") {
+			dir, ok := unescapeSparseCheckoutPattern(line[len("/") : len(line)-len("/")])
+			if !ok {
+				return nil, false
+			}
+			sparse.recursiveDirs[dir] = true
+		} else {
+			return nil, false
+		}
+	}
+
+	return sparse, seenRoot
+}
+
+// Git escapes the special characters of directory names with a backslash in cone mode patterns.
+// Returns false if the pattern is empty or has an unescaped wildcard, which is not allowed in cone mode.
+func unescapeSparseCheckoutPattern(pattern string) (string, bool) {
+	var ret strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+		case '*', '?', '[':
+			return "", false
+		}
+		ret.WriteByte(pattern[i])
+	}
+	return ret.String(), ret.Len() > 0
+}
+
+// Returns true if the file at relativePath (separated by forward slashes) is inside the sparse checkout.
+// Everything is included if sparse is nil.
+func (sparse *sparseCheckout) includes(relativePath string) bool {
+	if sparse == nil {
+		return true
+	}
+
+	if !sparse.cone {
+		// The last pattern matching the file decides, otherwise the one matching its closest folder.
+		// Same as path_in_sparse_checkout() in Git, so unlike with .gitignore files, a file can be included in an excluded folder.
+		for p, isDir := relativePath, false; ; isDir = true {
+			if pattern := sparse.patterns.lastMatchingPattern(p, isDir, false); pattern != nil {
+				return !pattern.negative
+			}
+
+			slash := strings.LastIndexByte(p, '/')
+			if slash == -1 {
+				return false
+			}
+			p = p[:slash]
+		}
+	}
+
+	dir := path.Dir(relativePath)
+	if dir == "." || sparse.parentDirs[dir] {
+		return true
+	}
+
+	for ; dir != "."; dir = path.Dir(dir) {
+		if sparse.recursiveDirs[dir] {
+			return true
+		}
+	}
+
+	return false
+}
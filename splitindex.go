@@ -0,0 +1,155 @@
+package gogitstatus
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// With core.splitIndex=true, most of the entries are stored in a shared index file at $GIT_DIR/sharedindex.<hash>,
+// and the index file only contains the entries that changed since then, along with a "link" extension.
+// https://github.com/git/git/blob/ef8ce8f3d4344fd3af049c17eeba5cd20d98b69f/split-index.c
+
+// Returns true if hash is all zeroes, which Git uses to mean "no hash"
+func isZeroHash(hash []byte) bool {
+	return bytes.Count(hash, []byte{0}) == len(hash)
+}
+
+// Merges a split index with the shared index it links to, which is read from gitDirPath (usually a ".git" folder).
+// Returns index unchanged if it is not a split index.
+// The returned GitIndex has the merged entries, and the extensions of index.
+func MergeSplitIndex(ctx context.Context, index *GitIndex, gitDirPath string) (*GitIndex, error) {
+	link := index.Extensions.SplitIndex
+	if link == nil || isZeroHash(link.SharedIndexHash) {
+		return index, nil
+	}
+
+	sharedIndexPath := filepath.Join(gitDirPath, "sharedindex."+hex.EncodeToString(link.SharedIndexHash))
+	sharedIndex, err := ParseGitIndexFull(ctx, sharedIndexPath, index.HashAlgorithm)
+	if err != nil {
+		return nil, errors.New("unable to read shared index " + sharedIndexPath + ": " + err.Error())
+	}
+
+	entries, err := mergeSplitIndexEntries(sharedIndex.Entries, index.Entries, link)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := *index
+	merged.Entries = entries
+	return &merged, nil
+}
+
+// The first entries of a split index replace the shared entries marked in the replace bitmap, in order.
+// They have an empty path name, since it is the same as the entry they replace.
+// The rest of the entries are new.
+func mergeSplitIndexEntries(sharedEntries, entries []GitIndexEntryFull, link *SplitIndexLink) ([]GitIndexEntryFull, error) {
+	deleted := make([]bool, len(sharedEntries))
+	replaced := make([]bool, len(sharedEntries))
+
+	outOfRange := -1
+	markBits := func(bitmap EWAHBitmap, marked []bool) {
+		bitmap.ForEachSetBit(func(position int) bool {
+			if position >= len(marked) {
+				outOfRange = position
+				return false
+			}
+			marked[position] = true
+			return true
+		})
+	}
+
+	markBits(link.DeleteBitmap, deleted)
+	markBits(link.ReplaceBitmap, replaced)
+	if outOfRange != -1 {
+		return nil, errors.New("split index bitmap position " + strconv.Itoa(outOfRange) + " is out of range, the shared index only has " + strconv.Itoa(len(sharedEntries)) + " entries")
+	}
+
+	merged := make([]GitIndexEntryFull, 0, len(sharedEntries)+len(entries))
+	numReplaced := 0
+	for i, e := range sharedEntries {
+		if replaced[i] {
+			if numReplaced >= len(entries) || entries[numReplaced].Path != "" {
+				return nil, errors.New("split index is missing the replacement for shared index entry " + strconv.Itoa(i))
+			}
+
+			replacement := entries[numReplaced]
+			replacement.Path = e.Path
+			merged = append(merged, replacement)
+			numReplaced++
+			continue
+		}
+
+		if !deleted[i] {
+			merged = append(merged, e)
+		}
+	}
+
+	for i, e := range entries[numReplaced:] {
+		if e.Path == "" {
+			return nil, errors.New("split index entry " + strconv.Itoa(numReplaced+i) + " has an empty path name, but does not replace a shared index entry")
+		}
+		merged = append(merged, e)
+	}
+
+	// Same order as in the index file, by path and then stage
+	slices.SortStableFunc(merged, func(a, b GitIndexEntryFull) int {
+		if c := strings.Compare(a.Path, b.Path); c != 0 {
+			return c
+		}
+		return int(a.Stage) - int(b.Stage)
+	})
+
+	return merged, nil
+}
+
+// Like ParseGitIndexWithHashAlgorithm(), but merges in the shared index from gitDirPath if the index file is a split index.
+// Git always looks for the shared index in the Git directory, even when the index file is somewhere else with GIT_INDEX_FILE.
+// Only the "link" extension is looked at, so this is as fast as ParseGitIndexWithHashAlgorithm() for an index that is not split.
+func parseGitIndexMergingSharedIndex(ctx context.Context, path string, gitDirPath string, hashAlgorithm HashAlgorithm) (map[string]GitIndexEntry, error) {
+	var entries map[string]GitIndexEntry
+	isSplitIndex := false
+	err := withFileData(path, func(data []byte) error {
+		var entriesEndOffset int
+		var err error
+		entries, _, entriesEndOffset, err = parseGitIndexEntriesFromMemory(ctx, data, -1, hashAlgorithm, nil)
+		if err != nil {
+			return err
+		}
+
+		link := findGitIndexExtension(data, entriesEndOffset, hashAlgorithm, "link")
+		isSplitIndex = len(link) >= hashAlgorithm.Size() && !isZeroHash(link[:hashAlgorithm.Size()])
+		return nil
+	})
+
+	if err != nil || !isSplitIndex {
+		return entries, err
+	}
+
+	if gitDirPath == "" {
+		return nil, errors.New("the shared index of a split index is in the Git directory, which is unknown")
+	}
+
+	// The replaced entries have no path name, so we need the entries in order
+	index, err := ParseGitIndexFull(ctx, path, hashAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err = MergeSplitIndex(ctx, index, gitDirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	entries = make(map[string]GitIndexEntry, len(index.Entries))
+	for _, e := range index.Entries {
+		addGitIndexEntryToMap(entries, e.Path, e.GitIndexEntry)
+	}
+
+	return entries, nil
+}
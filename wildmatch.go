@@ -0,0 +1,304 @@
+package gogitstatus
+
+import "strings"
+
+// Flags for wildmatch()
+const (
+	wildmatchCaseFold = 1 << iota // Case-insensitive matching (ASCII only)
+	wildmatchPathname             // "*" and "?" don't match "/", only "**" between slashes matches across folders
+)
+
+// Return values of doWildmatch()
+const (
+	wildmatchMatch           = 0
+	wildmatchNoMatch         = 1
+	wildmatchAbortAll        = -1
+	wildmatchAbortToStarStar = -2
+)
+
+// Returns true if text matches the shell wildcard pattern, exactly like Git.
+// This is a port of wildmatch.c, see: https://github.com/git/git/blob/ef8ce8f3d4344fd3af049c17eeba5cd20d98b69f/wildmatch.c
+func wildmatch(pattern, text string, flags int) bool {
+	return doWildmatch(pattern, text, flags) == wildmatchMatch
+}
+
+func isWildmatchSpecial(c byte) bool {
+	return c == '*' || c == '?' || c == '[' || c == '\\'
+}
+
+func asciiToLower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + ('a' - 'A')
+	}
+	return c
+}
+
+func asciiToUpper(c byte) byte {
+	if c >= 'a' && c <= 'z' {
+		return c - ('a' - 'A')
+	}
+	return c
+}
+
+// Matches a character class like "alpha" in "[[:alpha:]]", ok is false for an unknown class
+func matchesCharacterClass(class string, c byte, flags int) (matched bool, ok bool) {
+	isLower := c >= 'a' && c <= 'z'
+	isUpper := c >= 'A' && c <= 'Z'
+	isDigit := c >= '0' && c <= '9'
+	isAlpha := isLower || isUpper
+	isSpace := c == ' ' || c == '\t' || c == '\n' || c == '\v' || c == '\f' || c == '\r'
+	isPrint := c >= 0x20 && c < 0x7f
+
+	switch class {
+	case "alnum":
+		return isAlpha || isDigit, true
+	case "alpha":
+		return isAlpha, true
+	case "blank":
+		return c == ' ' || c == '\t', true
+	case "cntrl":
+		return c < 0x20 || c == 0x7f, true
+	case "digit":
+		return isDigit, true
+	case "graph":
+		return isPrint && c != ' ', true
+	case "lower":
+		return isLower, true
+	case "print":
+		return isPrint, true
+	case "punct":
+		return isPrint && c != ' ' && !isAlpha && !isDigit, true
+	case "space":
+		return isSpace, true
+	case "upper":
+		return isUpper || (flags&wildmatchCaseFold != 0 && isLower), true
+	case "xdigit":
+		return isDigit || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F'), true
+	default:
+		return false, false
+	}
+}
+
+func doWildmatch(pattern, text string, flags int) int {
+	// A null byte marks the end, like in the C code
+	at := func(s string, i int) byte {
+		if i < len(s) {
+			return s[i]
+		}
+		return 0
+	}
+
+	p := 0
+	t := 0
+	for ; p < len(pattern); t, p = t+1, p+1 {
+		pCh := pattern[p]
+		tCh := at(text, t)
+		if tCh == 0 && pCh != '*' {
+			return wildmatchAbortAll
+		}
+		if flags&wildmatchCaseFold != 0 {
+			tCh = asciiToLower(tCh)
+			pCh = asciiToLower(pCh)
+		}
+
+		switch pCh {
+		case '\\':
+			// Literal match with the following character
+			p++
+			pCh = at(pattern, p)
+			if tCh != pCh {
+				return wildmatchNoMatch
+			}
+		default:
+			if tCh != pCh {
+				return wildmatchNoMatch
+			}
+		case '?':
+			// Match anything but "/"
+			if flags&wildmatchPathname != 0 && tCh == '/' {
+				return wildmatchNoMatch
+			}
+		case '*':
+			var matchSlash bool
+			p++
+			if at(pattern, p) == '*' {
+				prevP := p - 2
+				for p++; at(pattern, p) == '*'; p++ {
+				}
+				if flags&wildmatchPathname == 0 {
+					// Without wildmatchPathname, "**" is the same as "*"
+					matchSlash = true
+				} else if (prevP < 0 || pattern[prevP] == '/') &&
+					(at(pattern, p) == 0 || at(pattern, p) == '/' || (at(pattern, p) == '\\' && at(pattern, p+1) == '/')) {
+					// Assuming we already match "foo/" and are at "**/", just assume it matches nothing
+					// and match the rest of the pattern with the remaining text.
+					// This makes "foo/**/bar" match both "foo/bar" and "foo/a/bar".
+					if at(pattern, p) == '/' && doWildmatch(pattern[p+1:], text[t:], flags) == wildmatchMatch {
+						return wildmatchMatch
+					}
+					matchSlash = true
+				}
+			} else {
+				// Without wildmatchPathname, "*" is the same as "**"
+				matchSlash = flags&wildmatchPathname == 0
+			}
+
+			if p >= len(pattern) {
+				// A trailing "**" matches everything, a trailing "*" only if there are no more slashes
+				if !matchSlash && strings.IndexByte(text[t:], '/') != -1 {
+					return wildmatchNoMatch
+				}
+				return wildmatchMatch
+			} else if !matchSlash && pattern[p] == '/' {
+				// A single asterisk followed by a slash matches the next folder
+				slash := strings.IndexByte(text[t:], '/')
+				if slash == -1 {
+					return wildmatchNoMatch
+				}
+				t += slash
+				// The slash is consumed by the loop
+				break
+			}
+
+			for {
+				if tCh == 0 {
+					break
+				}
+
+				// Skip ahead quickly when the asterisk is followed by a literal,
+				// without going past a slash if the asterisk can't match it.
+				if !isWildmatchSpecial(pattern[p]) {
+					pCh = pattern[p]
+					if flags&wildmatchCaseFold != 0 {
+						pCh = asciiToLower(pCh)
+					}
+					for {
+						tCh = at(text, t)
+						if tCh == 0 || (!matchSlash && tCh == '/') {
+							break
+						}
+						if flags&wildmatchCaseFold != 0 {
+							tCh = asciiToLower(tCh)
+						}
+						if tCh == pCh {
+							break
+						}
+						t++
+					}
+					if tCh != pCh {
+						return wildmatchNoMatch
+					}
+				}
+
+				matched := doWildmatch(pattern[p:], text[t:], flags)
+				if matched != wildmatchNoMatch {
+					if !matchSlash || matched != wildmatchAbortToStarStar {
+						return matched
+					}
+				} else if !matchSlash && tCh == '/' {
+					return wildmatchAbortToStarStar
+				}
+
+				t++
+				tCh = at(text, t)
+				if flags&wildmatchCaseFold != 0 {
+					tCh = asciiToLower(tCh)
+				}
+			}
+			return wildmatchAbortAll
+		case '[':
+			p++
+			pCh = at(pattern, p)
+			if pCh == '^' {
+				pCh = '!'
+			}
+			negated := pCh == '!'
+			if negated {
+				p++
+				pCh = at(pattern, p)
+			}
+
+			var prevCh byte
+			matched := false
+			for {
+				if pCh == 0 {
+					return wildmatchAbortAll
+				}
+
+				if pCh == '\\' {
+					p++
+					pCh = at(pattern, p)
+					if pCh == 0 {
+						return wildmatchAbortAll
+					}
+					if tCh == pCh {
+						matched = true
+					}
+				} else if pCh == '-' && prevCh != 0 && at(pattern, p+1) != 0 && at(pattern, p+1) != ']' {
+					p++
+					pCh = at(pattern, p)
+					if pCh == '\\' {
+						p++
+						pCh = at(pattern, p)
+						if pCh == 0 {
+							return wildmatchAbortAll
+						}
+					}
+					if tCh <= pCh && tCh >= prevCh {
+						matched = true
+					} else if flags&wildmatchCaseFold != 0 && tCh >= 'a' && tCh <= 'z' {
+						tChUpper := asciiToUpper(tCh)
+						if tChUpper <= pCh && tChUpper >= prevCh {
+							matched = true
+						}
+					}
+					pCh = 0 // This makes prevCh get set to 0
+				} else if pCh == '[' && at(pattern, p+1) == ':' {
+					p += 2
+					start := p
+					for ; at(pattern, p) != 0 && pattern[p] != ']'; p++ {
+					}
+					if at(pattern, p) == 0 {
+						return wildmatchAbortAll
+					}
+					if p-start-1 < 0 || pattern[p-1] != ':' {
+						// Didn't find ":]", so treat it like a normal set
+						p = start - 2
+						pCh = '['
+						if tCh == pCh {
+							matched = true
+						}
+					} else {
+						classMatched, ok := matchesCharacterClass(pattern[start:p-1], tCh, flags)
+						if !ok {
+							// Malformed [:class:] string
+							return wildmatchAbortAll
+						}
+						if classMatched {
+							matched = true
+						}
+						pCh = 0 // This makes prevCh get set to 0
+					}
+				} else if tCh == pCh {
+					matched = true
+				}
+
+				prevCh = pCh
+				p++
+				pCh = at(pattern, p)
+				if pCh == ']' {
+					break
+				}
+			}
+
+			if matched == negated || (flags&wildmatchPathname != 0 && tCh == '/') {
+				return wildmatchNoMatch
+			}
+		}
+	}
+
+	if t < len(text) {
+		return wildmatchNoMatch
+	}
+	return wildmatchMatch
+}